@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/buaazp/fasthttprouter"
+	"github.com/valyala/fasthttp"
+	"log"
+)
+
+/**
+ * 输出JSON响应
+ */
+func writeJson(ctx *fasthttp.RequestCtx, statusCode int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.SetBody(data)
+}
+
+/**
+ * GET /upstreams 全部服务节点的健康状态
+ */
+func UpstreamStatus(ctx *fasthttp.RequestCtx) {
+	writeJson(ctx, fasthttp.StatusOK, currentUpstreams().Status())
+}
+
+/**
+ * GET /circuits 全部熔断器状态
+ */
+func CircuitStatus(ctx *fasthttp.RequestCtx) {
+	writeJson(ctx, fasthttp.StatusOK, breakers.Status())
+}
+
+/**
+ * 网关管理接口,只应在内网开放
+ */
+func serveAdmin(addr string) {
+	router := fasthttprouter.New()
+	router.GET("/upstreams", UpstreamStatus)
+	router.GET("/circuits", CircuitStatus)
+
+	if err := fasthttp.ListenAndServe(addr, router.Handler); err != nil {
+		log.Fatalf("Error in admin ListenAndServe: %s", err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"log"
+	"skyway/gateway/skyrewrite"
+	"skyway/library"
+	"skyway/library/DataSource"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"sync/atomic"
+)
+
+// 认证通过后转发给后端的调用方名称
+const consumerHeader = "X-Consumer-Name"
+
+// 认证通过后保存在ctx中的调用方 *model.Consumer
+const consumerKey = "gateway.consumer"
+
+// 认证通过后保存在ctx中的Token subject
+const subjectKey = "gateway.subject"
+
+// 当前生效的调用方索引 *consumerIndex
+var consumers atomic.Value
+
+/**
+ * HMAC签名密钥及其所属调用方
+ */
+type hmacCredential struct {
+	consumer *model.Consumer
+	secret   string
+}
+
+/**
+ * 调用方索引,按API Key和HMAC KeyId查找
+ */
+type consumerIndex struct {
+	apiKeys  map[string]*model.Consumer
+	hmacKeys map[string]*hmacCredential
+}
+
+/**
+ * 从etcd读取全部调用方并建立索引
+ */
+func loadConsumers(client *DataSource.EtcdClient) (*consumerIndex, error) {
+	values, err := client.GetAll(DAO.CONSUMER_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &consumerIndex{
+		apiKeys:  make(map[string]*model.Consumer, len(values)),
+		hmacKeys: make(map[string]*hmacCredential),
+	}
+	for key, value := range values {
+		consumer := model.NewConsumer()
+		if err := json.UnmarshalFromString(value, consumer); err != nil {
+			log.Printf("skip consumer %s, invalid json: %s", key, err)
+			continue
+		}
+		for _, apiKey := range consumer.ApiKeys {
+			index.apiKeys[apiKey] = consumer
+		}
+		for _, hmacKey := range consumer.HmacKeys {
+			if hmacKey != nil {
+				index.hmacKeys[hmacKey.KeyId] = &hmacCredential{consumer: consumer, secret: hmacKey.Secret}
+			}
+		}
+	}
+	return index, nil
+}
+
+/**
+ * 重新加载调用方并原子替换索引
+ */
+func reloadConsumers(client *DataSource.EtcdClient) {
+	index, err := loadConsumers(client)
+	if err != nil {
+		log.Printf("reload consumers failed: %s", err)
+		return
+	}
+	consumers.Store(index)
+	log.Println("reload consumers done")
+}
+
+/**
+ * 当前生效的调用方索引
+ */
+func currentConsumers() *consumerIndex {
+	index, _ := consumers.Load().(*consumerIndex)
+	if index == nil {
+		return &consumerIndex{}
+	}
+	return index
+}
+
+/**
+ * 按API Key查找调用方,不存在时返回nil
+ */
+func findConsumer(apiKey string) *model.Consumer {
+	return currentConsumers().apiKeys[apiKey]
+}
+
+/**
+ * 按KeyId查找HMAC签名密钥,不存在时返回nil
+ */
+func findHmacCredential(keyId string) *hmacCredential {
+	return currentConsumers().hmacKeys[keyId]
+}
+
+/**
+ * 获取当前请求认证通过的调用方,未认证时返回nil
+ */
+func requestConsumer(ctx *fasthttp.RequestCtx) *model.Consumer {
+	consumer, _ := ctx.UserValue(consumerKey).(*model.Consumer)
+	return consumer
+}
+
+/**
+ * 当前请求的调用方标识: 调用方名称,其次为JWT或OAuth2 Token的subject;未认证时返回空字符串
+ */
+func requestSubject(ctx *fasthttp.RequestCtx) string {
+	if consumer := requestConsumer(ctx); consumer != nil {
+		return consumer.ConsumerName
+	}
+	subject, _ := ctx.UserValue(subjectKey).(string)
+	return subject
+}
+
+/**
+ * 重写前按API的认证配置检查调用方,缺少或未知凭证返回401,无权调用返回403
+ */
+func authenticate(ctx *fasthttp.RequestCtx, rewrite *skyrewrite.SkyRewrite) bool {
+	//调用方名称只能由网关设置
+	ctx.Request.Header.Del(consumerHeader)
+
+	api := rewrite.Api
+	if api == nil || api.Auth == nil {
+		return true
+	}
+	switch api.Auth.Type {
+	case model.AUTH_KEY:
+		return keyAuth(ctx, api)
+	case model.AUTH_JWT:
+		return jwtAuth(ctx, api)
+	case model.AUTH_HMAC:
+		return hmacAuth(ctx, api)
+	case model.AUTH_OAUTH2:
+		return oauth2Auth(ctx, api)
+	}
+	ctx.Logger().Printf("unknown auth type %s of api %d", api.Auth.Type, api.ApiId)
+	writeError(ctx, api.ApiId, fasthttp.StatusInternalServerError, ServiceApi.CODE_INTERNAL_ERROR, "unknown auth type")
+	return false
+}
+
+/**
+ * API Key认证,Key从请求头或QueryString读取,转发前移除
+ */
+func keyAuth(ctx *fasthttp.RequestCtx, api *model.Api) bool {
+	header := api.Auth.Header()
+	query := api.Auth.Query()
+	apiKey := string(ctx.Request.Header.Peek(header))
+	if apiKey == "" {
+		apiKey = string(ctx.QueryArgs().Peek(query))
+	}
+	if apiKey == "" {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "missing api key")
+		return false
+	}
+
+	consumer := findConsumer(apiKey)
+	if consumer == nil {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "invalid api key")
+		return false
+	}
+	if !consumer.Allowed(api) {
+		ctx.Logger().Printf("consumer %s is not allowed to call api %d", consumer.ConsumerName, api.ApiId)
+		writeError(ctx, api.ApiId, fasthttp.StatusForbidden, ServiceApi.CODE_FORBIDDEN, "api not allowed for consumer")
+		return false
+	}
+
+	//Key不转发给后端
+	ctx.Request.Header.Del(header)
+	if args := ctx.URI().QueryArgs(); args.Has(query) {
+		args.Del(query)
+		ctx.URI().SetQueryStringBytes(args.QueryString())
+	}
+	setConsumer(ctx, consumer)
+	return true
+}
+
+/**
+ * 记录认证通过的调用方,并通过请求头转发给后端
+ */
+func setConsumer(ctx *fasthttp.RequestCtx, consumer *model.Consumer) {
+	ctx.Request.Header.Set(consumerHeader, consumer.ConsumerName)
+	ctx.SetUserValue(consumerKey, consumer)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"skyway/gateway/skyupstream"
+	"skyway/library"
+	"strconv"
+	"time"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// 网关实例标识,与连接内请求序号组成请求ID
+var instanceId = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+/**
+ * 获取请求ID,优先使用客户端传入的X-Request-Id,没有时生成一个并写入请求头转发给后端
+ */
+func requestId(ctx *fasthttp.RequestCtx) string {
+	if id := ctx.Request.Header.Peek(requestIdHeader); len(id) > 0 {
+		return string(id)
+	}
+	id := instanceId + "-" + strconv.FormatUint(ctx.ID(), 36)
+	ctx.Request.Header.Set(requestIdHeader, id)
+	return id
+}
+
+/**
+ * 输出网关错误响应
+ */
+func writeError(ctx *fasthttp.RequestCtx, apiId int, statusCode int, code string, message string) {
+	response := ServiceApi.NewDataResponse(code, message)
+	response.RequestId = requestId(ctx)
+	response.ApiId = apiId
+	response.Write(ctx, statusCode)
+	ctx.Response.Header.Set(requestIdHeader, response.RequestId)
+}
+
+/**
+ * 转发失败时按错误类型输出: 连接失败502,连接,读写,总超时504,熔断和无可用节点503
+ */
+func writeProxyError(ctx *fasthttp.RequestCtx, apiId int, err error) {
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		writeError(ctx, apiId, fasthttp.StatusServiceUnavailable, ServiceApi.CODE_CIRCUIT_OPEN, "circuit open")
+		return
+	case errors.Is(err, errNoTarget):
+		writeError(ctx, apiId, fasthttp.StatusServiceUnavailable, ServiceApi.CODE_NO_HEALTHY_UPSTREAM, "no healthy upstream target")
+		return
+	}
+
+	switch skyupstream.Classify(err) {
+	case skyupstream.ErrorConnect:
+		writeError(ctx, apiId, fasthttp.StatusBadGateway, ServiceApi.CODE_UPSTREAM_CONNECT, "upstream connect failed")
+	case skyupstream.ErrorConnectTimeout:
+		writeError(ctx, apiId, fasthttp.StatusGatewayTimeout, ServiceApi.CODE_UPSTREAM_TIMEOUT, "upstream connect timeout")
+	case skyupstream.ErrorTimeout:
+		if errors.Is(err, fasthttp.ErrTimeout) {
+			writeError(ctx, apiId, fasthttp.StatusGatewayTimeout, ServiceApi.CODE_UPSTREAM_TIMEOUT, "upstream request timeout")
+		} else {
+			writeError(ctx, apiId, fasthttp.StatusGatewayTimeout, ServiceApi.CODE_UPSTREAM_TIMEOUT, "upstream read or write timeout")
+		}
+	default:
+		writeError(ctx, apiId, fasthttp.StatusBadGateway, ServiceApi.CODE_UPSTREAM_ERROR, "upstream error")
+	}
+}
+
+/**
+ * 没有匹配的路由
+ */
+func NotFound(ctx *fasthttp.RequestCtx) {
+	writeError(ctx, 0, fasthttp.StatusNotFound, ServiceApi.CODE_API_NOT_FOUND, "api not found")
+}
+
+/**
+ * 路由存在但请求方法不匹配
+ */
+func MethodNotAllowed(ctx *fasthttp.RequestCtx) {
+	allow := string(ctx.Response.Header.Peek("Allow"))
+	writeError(ctx, 0, fasthttp.StatusMethodNotAllowed, ServiceApi.CODE_METHOD_NOT_ALLOWED, "method not allowed")
+	ctx.Response.Header.Set("Allow", allow)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"skyway/gateway/skyhmac"
+	"skyway/library"
+	"skyway/managerapi/model"
+	"strconv"
+	"time"
+)
+
+// 默认允许的时钟偏差,毫秒
+const defaultClockSkew = 300000
+
+// 已使用的nonce,按KeyId区分
+var nonces = skyhmac.NewNonceCache()
+
+/**
+ * HMAC签名认证: 按KeyId找到调用方的密钥,检查时间戳在允许偏差内,签名正确且nonce未使用过;
+ * 缺少或错误的签名返回401,调用方无权调用返回403
+ */
+func hmacAuth(ctx *fasthttp.RequestCtx, api *model.Api) bool {
+	clockSkew := defaultClockSkew
+	if policy := api.Auth.Hmac; policy != nil && policy.ClockSkew > 0 {
+		clockSkew = policy.ClockSkew
+	}
+	skew := time.Duration(clockSkew) * time.Millisecond
+
+	keyId := string(ctx.Request.Header.Peek(skyhmac.HEADER_KEY_ID))
+	timestamp := string(ctx.Request.Header.Peek(skyhmac.HEADER_TIMESTAMP))
+	nonce := string(ctx.Request.Header.Peek(skyhmac.HEADER_NONCE))
+	signature := string(ctx.Request.Header.Peek(skyhmac.HEADER_SIGNATURE))
+	if keyId == "" || timestamp == "" || nonce == "" || signature == "" {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "missing signature headers")
+		return false
+	}
+
+	credential := findHmacCredential(keyId)
+	if credential == nil {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "invalid key id")
+		return false
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "invalid timestamp")
+		return false
+	}
+	now := time.Now()
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-skew)) || signedAt.After(now.Add(skew)) {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "timestamp outside allowed clock skew")
+		return false
+	}
+
+	params := make([]skyhmac.Param, 0)
+	ctx.QueryArgs().VisitAll(func(key, value []byte) {
+		params = append(params, skyhmac.Param{Key: string(key), Value: string(value)})
+	})
+	canonical := skyhmac.Canonical(string(ctx.Method()), string(ctx.URI().PathOriginal()), params, timestamp, nonce, ctx.PostBody())
+	if !skyhmac.Verify(credential.secret, canonical, signature) {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "signature mismatch")
+		return false
+	}
+
+	//签名正确后才记录nonce,避免伪造请求占用nonce;时间戳超出偏差后nonce无需保留
+	if !nonces.Use(keyId+":"+nonce, signedAt.Add(skew), now) {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "nonce already used")
+		return false
+	}
+
+	consumer := credential.consumer
+	if !consumer.Allowed(api) {
+		ctx.Logger().Printf("consumer %s is not allowed to call api %d", consumer.ConsumerName, api.ApiId)
+		writeError(ctx, api.ApiId, fasthttp.StatusForbidden, ServiceApi.CODE_FORBIDDEN, "api not allowed for consumer")
+		return false
+	}
+	setConsumer(ctx, consumer)
+	return true
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/valyala/fasthttp"
+	"log"
+	"skyway/gateway/skyjwt"
+	"skyway/library"
+	"skyway/library/DataSource"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 当前生效的etcd中的JWKS map[string]*skyjwt.KeySet
+var jwksSets atomic.Value
+
+// 网关本地JWKS文件所在目录,API只能引用该目录下的文件;为空时不允许使用本地JWKS文件
+var jwksDir = flag.String("jwks-dir", "", "directory of local jwks files referenced by name in JwksFile, empty disables jwks files")
+
+// 网关本地的JWKS文件,main中按jwksDir初始化
+var jwksFiles *skyjwt.FileKeySets
+
+// 加载路由时解析的JWT静态密钥,按认证配置索引 map[*model.JwtPolicy]*staticJwtKeys
+var jwtStaticKeys atomic.Value
+
+/**
+ * 认证配置中Secret和PublicKey解析出的密钥,err为PublicKey的解析错误
+ */
+type staticJwtKeys struct {
+	keys []*skyjwt.Key
+	err  error
+}
+
+/**
+ * 从etcd读取全部JWKS,按名称索引;解析失败的JWKS跳过
+ */
+func loadJwks(client *DataSource.EtcdClient) (map[string]*skyjwt.KeySet, error) {
+	values, err := client.GetAll(DAO.JWKS_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make(map[string]*skyjwt.KeySet, len(values))
+	for key, value := range values {
+		set, err := skyjwt.ParseJwks([]byte(value))
+		if err != nil {
+			log.Printf("skip jwks %s: %s", key, err)
+			continue
+		}
+		sets[strings.TrimPrefix(key, DAO.JWKS_PREFIX)] = set
+	}
+	return sets, nil
+}
+
+/**
+ * 重新加载etcd中的JWKS并原子替换
+ */
+func reloadJwks(client *DataSource.EtcdClient) {
+	sets, err := loadJwks(client)
+	if err != nil {
+		log.Printf("reload jwks failed: %s", err)
+		return
+	}
+	jwksSets.Store(sets)
+	log.Println("reload jwks done")
+}
+
+/**
+ * 解析JWT认证配置中的Secret和PublicKey,PublicKey解析失败时只保留Secret
+ */
+func parseStaticJwtKeys(policy *model.JwtPolicy) *staticJwtKeys {
+	static := &staticJwtKeys{keys: make([]*skyjwt.Key, 0, 2)}
+	if policy.Secret != "" {
+		static.keys = append(static.keys, skyjwt.SecretKey(policy.Secret))
+	}
+	if policy.PublicKey != "" {
+		key, err := skyjwt.ParsePublicKey(policy.PublicKey)
+		if err != nil {
+			static.err = err
+		} else {
+			static.keys = append(static.keys, key)
+		}
+	}
+	return static
+}
+
+/**
+ * 加载路由时解析全部JWT认证API的静态密钥,避免每个请求重复解析PublicKey;解析失败输出日志
+ */
+func loadJwtKeys(apis []*model.Api) map[*model.JwtPolicy]*staticJwtKeys {
+	cache := make(map[*model.JwtPolicy]*staticJwtKeys)
+	for _, api := range apis {
+		if api.Auth == nil || api.Auth.Type != model.AUTH_JWT || api.Auth.Jwt == nil {
+			continue
+		}
+		policy := api.Auth.Jwt
+		if _, ok := cache[policy]; ok {
+			continue
+		}
+		static := parseStaticJwtKeys(policy)
+		if static.err != nil {
+			log.Printf("skip public key of api %d: %s", api.ApiId, static.err)
+		}
+		cache[policy] = static
+	}
+	return cache
+}
+
+/**
+ * 收集JWT认证配置中的全部密钥;加载失败的密钥来源跳过,其他来源的密钥仍可验证,
+ * 只有没有任何可用密钥且有来源加载失败时返回error
+ */
+func jwtKeys(ctx *fasthttp.RequestCtx, api *model.Api, policy *model.JwtPolicy) ([]*skyjwt.Key, error) {
+	cache, _ := jwtStaticKeys.Load().(map[*model.JwtPolicy]*staticJwtKeys)
+	static := cache[policy]
+	if static == nil {
+		//路由替换期间仍在旧路由上的请求,缓存已是新路由的配置
+		static = parseStaticJwtKeys(policy)
+	}
+	failed := static.err
+
+	keys := make([]*skyjwt.Key, 0, len(static.keys))
+	keys = append(keys, static.keys...)
+	if policy.JwksName != "" {
+		sets, _ := jwksSets.Load().(map[string]*skyjwt.KeySet)
+		if set := sets[policy.JwksName]; set != nil {
+			keys = append(keys, set.Keys...)
+		}
+	}
+	if policy.JwksFile != "" {
+		set, err := jwksFiles.Get(policy.JwksFile)
+		if err != nil {
+			ctx.Logger().Printf("skip jwks file %s of api %d: %s", policy.JwksFile, api.ApiId, err)
+			failed = err
+		} else {
+			keys = append(keys, set.Keys...)
+		}
+	}
+	if len(keys) == 0 && failed != nil {
+		return nil, failed
+	}
+	return keys, nil
+}
+
+/**
+ * JWT认证,Token从Authorization: Bearer读取;无效Token返回401,缺少要求的claim返回403;
+ * 通过后按ClaimHeaders把claim转发给后端
+ */
+func jwtAuth(ctx *fasthttp.RequestCtx, api *model.Api) bool {
+	policy := api.Auth.Jwt
+	//转发的claim请求头只能由网关设置
+	for _, header := range policy.ClaimHeaders {
+		ctx.Request.Header.Del(header)
+	}
+
+	token := bearerToken(ctx)
+	if token == "" {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "missing bearer token")
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="skyway"`)
+		return false
+	}
+	keys, err := jwtKeys(ctx, api, policy)
+	if err != nil {
+		ctx.Logger().Printf("load jwt keys of api %d failed: %s", api.ApiId, err)
+		writeError(ctx, api.ApiId, fasthttp.StatusInternalServerError, ServiceApi.CODE_INTERNAL_ERROR, "jwt keys unavailable")
+		return false
+	}
+
+	claims, err := skyjwt.Verify(token, policy, keys, time.Now())
+	switch {
+	case err == nil:
+	case errors.Is(err, skyjwt.ErrInsufficientClaims):
+		ctx.Logger().Printf("jwt of api %d rejected: %s", api.ApiId, err)
+		writeError(ctx, api.ApiId, fasthttp.StatusForbidden, ServiceApi.CODE_FORBIDDEN, err.Error())
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="skyway", error="insufficient_scope"`)
+		return false
+	default:
+		ctx.Logger().Printf("jwt of api %d rejected: %s", api.ApiId, err)
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, err.Error())
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="skyway", error="invalid_token"`)
+		return false
+	}
+
+	for claim, header := range policy.ClaimHeaders {
+		if value, ok := claims[claim]; ok {
+			ctx.Request.Header.Set(header, skyjwt.ClaimString(value))
+		}
+	}
+	if sub := skyjwt.ClaimString(claims["sub"]); sub != "" {
+		ctx.SetUserValue(subjectKey, sub)
+	}
+	return true
+}
+
+/**
+ * 读取Authorization: Bearer中的Token,没有时返回空字符串
+ */
+func bearerToken(ctx *fasthttp.RequestCtx) string {
+	authorization := string(ctx.Request.Header.Peek("Authorization"))
+	if len(authorization) < len("Bearer ") || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(authorization[len("Bearer "):])
+}
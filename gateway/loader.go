@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"log"
+	"skyway/gateway/skylimit"
+	"skyway/gateway/skyrewrite"
+	"skyway/gateway/skyrouter"
+	"skyway/gateway/skyupstream"
+	"skyway/library/DataSource"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// 网关服务的环境,只加载发布到该环境的API
+var environment = flag.String("env", model.ENV_PROD, "environment served by this gateway: "+strings.Join(model.Environments, ", "))
+
+// 当前生效的服务注册表 *skyupstream.Registry
+var upstreams atomic.Value
+
+/**
+ * 从etcd读取当前环境已发布的全部API定义;
+ * 旧版本直接加载API_下的定义,升级时由管理服务启动时的controller.MigrateLegacyApis校验后发布到各环境
+ */
+func loadApis(client *DataSource.EtcdClient) ([]*model.Api, error) {
+	values, err := client.GetAll(DAO.PublishedApiPrefix(*environment))
+	if err != nil {
+		return nil, err
+	}
+
+	apis := make([]*model.Api, 0, len(values))
+	for key, value := range values {
+		api := model.NewApi()
+		if err := json.UnmarshalFromString(value, api); err != nil {
+			log.Printf("skip api %s, invalid json: %s", key, err)
+			continue
+		}
+		api.RateLimits = validRateLimits(fmt.Sprintf("api %d", api.ApiId), api.RateLimits)
+		if api.Group != nil {
+			api.Group.RateLimits = validRateLimits(fmt.Sprintf("group %d of api %d", api.Group.GroupId, api.ApiId), api.Group.RateLimits)
+		}
+		apis = append(apis, api)
+	}
+	return apis, nil
+}
+
+/**
+ * 从etcd读取全部分组,按ID索引
+ */
+func loadGroups(client *DataSource.EtcdClient) (map[int]*model.Group, error) {
+	values, err := client.GetAll(DAO.GROUP_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[int]*model.Group, len(values))
+	for key, value := range values {
+		group := model.NewGroup()
+		if err := json.UnmarshalFromString(value, group); err != nil {
+			log.Printf("skip group %s, invalid json: %s", key, err)
+			continue
+		}
+		group.RateLimits = validRateLimits(fmt.Sprintf("group %d", group.GroupId), group.RateLimits)
+		groups[group.GroupId] = group
+	}
+	return groups, nil
+}
+
+/**
+ * 去掉Limit或Window不大于0的限流规则并逐条输出日志,其余规则保持顺序
+ */
+func validRateLimits(owner string, limits []*model.RateLimit) []*model.RateLimit {
+	valid := make([]*model.RateLimit, 0, len(limits))
+	for index, limit := range limits {
+		if limit == nil || !skylimit.Valid(limit) {
+			log.Printf("drop rate limit %d of %s: limit and window must be positive", index, owner)
+			continue
+		}
+		valid = append(valid, limit)
+	}
+	return valid
+}
+
+/**
+ * model.Api转为SkyRewrite,group为API所属分组,未分组时为nil
+ */
+func newRewrite(api *model.Api, group *model.Group) *skyrewrite.SkyRewrite {
+	rewrite := skyrewrite.New()
+	rewrite.ApiId = api.ApiId
+	rewrite.ServiceId = api.ServiceId
+	rewrite.Api = api
+	rewrite.Group = group
+	rewrite.OriginUri = api.OriginUriPattern
+	rewrite.DestUri = api.DestUriPattern
+	return rewrite
+}
+
+/**
+ * 注册单个API到路由,非法的路由定义会导致panic,这里转为error,避免影响其他API
+ */
+func registerApi(router *skyrouter.Router, api *model.Api, group *model.Group) (err error) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			err = fmt.Errorf("%v", rcv)
+		}
+	}()
+
+	if len(api.OriginUriPattern) == 0 || api.OriginUriPattern[0] != '/' {
+		return fmt.Errorf("invalid origin uri pattern '%s'", api.OriginUriPattern)
+	}
+
+	method := strings.ToUpper(api.Method)
+	if method == "" {
+		method = "GET"
+	}
+	router.Handle(method, api.OriginUriPattern, newRewrite(api, group))
+	return nil
+}
+
+/**
+ * 根据etcd中的API定义创建路由,API合并发布时保存的分组快照;同时返回注册成功的API
+ */
+func loadRouter(client *DataSource.EtcdClient) (*skyrouter.Router, []*model.Api, error) {
+	apis, err := loadApis(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	groups, err := loadGroups(client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	router := skyrouter.New()
+	routed := make([]*model.Api, 0, len(apis))
+	for _, api := range apis {
+		//升级前发布的API没有分组快照,使用分组的当前设置
+		group := api.EffectiveGroup(groups)
+		if api.GroupId != 0 && group == nil {
+			log.Printf("skip api %d: group %d not found", api.ApiId, api.GroupId)
+			continue
+		}
+		api = group.Apply(api)
+		if err := registerApi(router, api, group); err != nil {
+			log.Printf("skip api %d: %s", api.ApiId, err)
+			continue
+		}
+		log.Printf("register api %d: %s %s -> %s", api.ApiId, api.Method, api.OriginUriPattern, api.DestUriPattern)
+		routed = append(routed, api)
+	}
+	return router, routed, nil
+}
+
+// 串行化路由重新加载,API和分组的监听各自触发,读到旧数据的加载不能晚于新数据替换
+var reloadRouterMu sync.Mutex
+
+/**
+ * 重新加载API并原子替换运行中的路由
+ */
+func reloadRouter(client *DataSource.EtcdClient, router *skyrouter.Router) {
+	reloadRouterMu.Lock()
+	defer reloadRouterMu.Unlock()
+
+	fresh, apis, err := loadRouter(client)
+	if err != nil {
+		log.Printf("reload apis failed: %s", err)
+		return
+	}
+	//先替换密钥再替换路由,新路由上的请求总能命中缓存
+	jwtStaticKeys.Store(loadJwtKeys(apis))
+	router.Swap(fresh)
+	routedApis.Store(apis)
+	pruneBreakers()
+	log.Println("reload apis done")
+}
+
+/**
+ * 从etcd读取全部服务定义
+ */
+func loadServices(client *DataSource.EtcdClient) ([]*model.Service, error) {
+	values, err := client.GetAll(DAO.SERVICE_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]*model.Service, 0, len(values))
+	for key, value := range values {
+		service := model.NewService()
+		if err := json.UnmarshalFromString(value, service); err != nil {
+			log.Printf("skip service %s, invalid json: %s", key, err)
+			continue
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+/**
+ * 重新加载服务并原子替换服务注册表
+ */
+func reloadUpstreams(client *DataSource.EtcdClient) {
+	services, err := loadServices(client)
+	if err != nil {
+		log.Printf("reload services failed: %s", err)
+		return
+	}
+	previous := currentUpstreams()
+	upstreams.Store(skyupstream.NewRegistry(services, previous))
+	previous.Stop()
+	pruneBreakers()
+	log.Println("reload services done")
+}
+
+/**
+ * 获取当前生效的服务注册表
+ */
+func currentUpstreams() *skyupstream.Registry {
+	registry, _ := upstreams.Load().(*skyupstream.Registry)
+	return registry
+}
+
+/**
+ * 监听指定前缀,有新增,修改,删除时调用reload;监听断开后自动重连
+ */
+func watchPrefix(client *DataSource.EtcdClient, prefix string, reload func()) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		watchChan := client.WatchAll(ctx, prefix)
+		//重连期间的变更会丢失,重新建立监听后先全量加载一次
+		reload()
+
+		for resp := range watchChan {
+			if err := resp.Err(); err != nil {
+				log.Printf("watch %s failed: %s", prefix, err)
+				break
+			}
+			if len(resp.Events) > 0 {
+				reload()
+			}
+		}
+		cancel()
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+	"skyway/gateway/skyoauth"
+	"skyway/library"
+	"skyway/managerapi/model"
+	"strings"
+)
+
+/**
+ * 默认转发给后端的subject和scope请求头
+ */
+const (
+	defaultSubjectHeader = "X-Auth-Subject"
+	defaultScopeHeader   = "X-Auth-Scope"
+)
+
+// 令牌自省客户端,结果在各API间共享缓存
+var introspector = skyoauth.NewIntrospector()
+
+/**
+ * OAuth2令牌自省认证: 无效Token返回401,缺少scope返回403,授权服务器不可用返回503;
+ * 通过后把subject和scope转发给后端
+ */
+func oauth2Auth(ctx *fasthttp.RequestCtx, api *model.Api) bool {
+	policy := api.Auth.OAuth2
+	subjectHeader := policy.SubjectHeader
+	if subjectHeader == "" {
+		subjectHeader = defaultSubjectHeader
+	}
+	scopeHeader := policy.ScopeHeader
+	if scopeHeader == "" {
+		scopeHeader = defaultScopeHeader
+	}
+	//subject和scope只能由网关设置
+	ctx.Request.Header.Del(subjectHeader)
+	ctx.Request.Header.Del(scopeHeader)
+
+	token := bearerToken(ctx)
+	if token == "" {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "missing bearer token")
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="skyway"`)
+		return false
+	}
+
+	result, err := introspector.Introspect(policy, token)
+	if err != nil {
+		ctx.Logger().Printf("introspect token of api %d failed: %s", api.ApiId, err)
+		writeError(ctx, api.ApiId, fasthttp.StatusServiceUnavailable, ServiceApi.CODE_AUTH_UNAVAILABLE, "token introspection unavailable")
+		return false
+	}
+	if !result.Active {
+		writeError(ctx, api.ApiId, fasthttp.StatusUnauthorized, ServiceApi.CODE_UNAUTHORIZED, "inactive token")
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="skyway", error="invalid_token"`)
+		return false
+	}
+	if scope, ok := result.HasScopes(policy.RequiredScopes); !ok {
+		writeError(ctx, api.ApiId, fasthttp.StatusForbidden, ServiceApi.CODE_FORBIDDEN, "missing scope "+scope)
+		ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="skyway", error="insufficient_scope", scope="`+strings.Join(policy.RequiredScopes, " ")+`"`)
+		return false
+	}
+
+	subject := result.Sub
+	if subject == "" {
+		subject = result.Username
+	}
+	if subject != "" {
+		ctx.Request.Header.Set(subjectHeader, subject)
+		ctx.SetUserValue(subjectKey, subject)
+	}
+	if result.Scope != "" {
+		ctx.Request.Header.Set(scopeHeader, result.Scope)
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"skyway/gateway/skybreaker"
+	"skyway/gateway/skyupstream"
+	"skyway/library"
+	"skyway/managerapi/model"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errNoTarget    = errors.New("no healthy upstream target")
+	errCircuitOpen = errors.New("circuit open")
+)
+
+// 全部熔断器,路由重新加载时保留状态
+var breakers = skybreaker.NewGroup()
+
+// 当前路由中的API []*model.Api,服务重新加载时据此清理熔断器
+var routedApis atomic.Value
+
+// 每个API的重试预算
+var retryBudgets = skyupstream.NewRetryBudgets()
+
+/**
+ * API级熔断器,未配置熔断时返回nil
+ */
+func apiBreaker(api *model.Api) *skybreaker.Breaker {
+	if api == nil {
+		return nil
+	}
+	return breakers.Get(apiBreakerName(api), api.CircuitBreaker)
+}
+
+func apiBreakerName(api *model.Api) string {
+	return fmt.Sprintf("api:%d", api.ApiId)
+}
+
+/**
+ * API下单个后端节点的熔断器,未配置熔断时返回nil
+ */
+func targetBreaker(api *model.Api, target *skyupstream.Target) *skybreaker.Breaker {
+	if api == nil {
+		return nil
+	}
+	return breakers.Get(targetBreakerName(api, target), api.CircuitBreaker)
+}
+
+func targetBreakerName(api *model.Api, target *skyupstream.Target) string {
+	return fmt.Sprintf("api:%d/target:%s", api.ApiId, target.Addr)
+}
+
+/**
+ * 路由或服务重新加载后,删除已不在路由中的API和已不在服务中的节点的熔断器
+ */
+func pruneBreakers() {
+	apis, _ := routedApis.Load().([]*model.Api)
+	registry := currentUpstreams()
+	names := make(map[string]bool)
+	for _, api := range apis {
+		if api.CircuitBreaker == nil {
+			continue
+		}
+		names[apiBreakerName(api)] = true
+		if upstream := registry.Get(api.ServiceId); upstream != nil {
+			for _, target := range upstream.Targets {
+				names[targetBreakerName(api, target)] = true
+			}
+		}
+	}
+	breakers.Retain(names)
+}
+
+/**
+ * 熔断时返回配置的降级响应,未配置时返回503错误
+ */
+func writeFallback(ctx *fasthttp.RequestCtx, api *model.Api) {
+	config := api.CircuitBreaker
+	statusCode := config.FallbackStatus
+	if statusCode == 0 {
+		statusCode = fasthttp.StatusServiceUnavailable
+	}
+	if config.FallbackBody == "" {
+		writeError(ctx, api.ApiId, statusCode, ServiceApi.CODE_CIRCUIT_OPEN, "circuit open")
+		return
+	}
+
+	ctx.Response.Reset()
+	ctx.SetStatusCode(statusCode)
+	if config.FallbackContentType != "" {
+		ctx.SetContentType(config.FallbackContentType)
+	}
+	ctx.SetBodyString(config.FallbackBody)
+}
+
+/**
+ * 选择一个健康且未熔断的节点,优先选择本次请求还未尝试过的节点
+ */
+func pickTarget(ctx *fasthttp.RequestCtx, api *model.Api, upstream *skyupstream.Upstream, tried map[*skyupstream.Target]bool) *skyupstream.Target {
+	ready := func(target *skyupstream.Target) bool {
+		circuit := targetBreaker(api, target)
+		return circuit == nil || circuit.Ready()
+	}
+	if len(tried) > 0 {
+		target := upstream.Pick(ctx, func(target *skyupstream.Target) bool {
+			return !tried[target] && ready(target)
+		})
+		if target != nil {
+			return target
+		}
+	}
+	return upstream.Pick(ctx, ready)
+}
+
+/**
+ * 向一个节点转发一次请求
+ */
+func proxyOnce(ctx *fasthttp.RequestCtx, api *model.Api, target *skyupstream.Target, timeouts model.Timeouts) error {
+	circuit := targetBreaker(api, target)
+	if circuit != nil && !circuit.Allow() {
+		return errCircuitOpen
+	}
+
+	start := time.Now()
+	resp := &ctx.Response
+	err := target.Do(&ctx.Request, resp, timeouts)
+	target.Report(resp.StatusCode(), err)
+	if circuit != nil {
+		circuit.Record(err == nil && resp.StatusCode() < fasthttp.StatusInternalServerError, time.Since(start))
+	}
+	return err
+}
+
+/**
+ * 转发请求,结果写入ctx.Response;按API的重试策略重试,每次重试优先换一个节点
+ */
+func proxyRequest(ctx *fasthttp.RequestCtx, api *model.Api, upstream *skyupstream.Upstream) error {
+	var policy *model.RetryPolicy
+	var budget *skyupstream.RetryBudget
+	if api != nil && api.Retry != nil && api.Retry.MaxAttempts > 1 {
+		policy = api.Retry
+		budget = retryBudgets.Get(api.ApiId)
+		budget.Request()
+	}
+
+	var override *model.Timeouts
+	if api != nil {
+		override = api.Timeouts
+	}
+	timeouts := upstream.Timeouts(override)
+
+	method := string(ctx.Method())
+	tried := make(map[*skyupstream.Target]bool)
+	for attempt := 1; ; attempt++ {
+		target := pickTarget(ctx, api, upstream, tried)
+		if target == nil {
+			if len(upstream.Available(nil)) > 0 {
+				return errCircuitOpen
+			}
+			return errNoTarget
+		}
+		tried[target] = true
+
+		err := proxyOnce(ctx, api, target, timeouts)
+		if err == errCircuitOpen {
+			return err
+		}
+		if policy == nil || attempt >= policy.MaxAttempts ||
+			!skyupstream.ShouldRetry(policy, method, ctx.Response.StatusCode(), err) ||
+			!budget.Withdraw(policy) {
+			if err != nil {
+				return fmt.Errorf("proxy to %s: %w", target.Addr, err)
+			}
+			return nil
+		}
+
+		ctx.Logger().Printf("retry %d of api %d after %s, status=%d, err=%v", attempt, api.ApiId, target.Addr, ctx.Response.StatusCode(), err)
+		time.Sleep(skyupstream.Backoff(policy, attempt))
+		ctx.Response.Reset()
+	}
+}
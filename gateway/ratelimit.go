@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"math"
+	"skyway/gateway/skylimit"
+	"skyway/gateway/skyrewrite"
+	"skyway/library"
+	"skyway/managerapi/model"
+	"strconv"
+	"time"
+)
+
+// 放行后保存在ctx中的限流结果 *rateLimitResult
+const rateLimitKey = "gateway.ratelimit"
+
+var (
+	localLimits = skylimit.NewLocalStore()
+	// etcd共享模式的限流计数,main中初始化
+	sharedLimits *skylimit.EtcdStore
+)
+
+/**
+ * 生效的限流规则及其判断结果,输出RateLimit-*响应头
+ */
+type rateLimitResult struct {
+	limit    *model.RateLimit
+	decision skylimit.Decision
+}
+
+/**
+ * 限流计数的key: 计数范围(API ID,分组规则为g加分组ID),规则序号和限流维度的取值
+ */
+func limitKey(ctx *fasthttp.RequestCtx, scope string, index int, limit *model.RateLimit) string {
+	var value string
+	switch limit.Key {
+	case model.RATE_LIMIT_KEY_API:
+	case model.RATE_LIMIT_KEY_CONSUMER:
+		value = requestSubject(ctx)
+		if value == "" {
+			value = "ip:" + ctx.RemoteIP().String()
+		}
+	case model.RATE_LIMIT_KEY_HEADER:
+		value = string(ctx.Request.Header.Peek(limit.Header))
+	default:
+		value = ctx.RemoteIP().String()
+	}
+	return fmt.Sprintf("%s:%d:%s:%s", scope, index, limit.Key, value)
+}
+
+/**
+ * 按规则消耗一次请求;共享模式下只在etcd不可用时放行,避免限流故障影响转发,并发冲突按超限处理
+ */
+func takeLimit(ctx *fasthttp.RequestCtx, key string, limit *model.RateLimit, now time.Time) (skylimit.Decision, bool) {
+	if !limit.Shared || sharedLimits == nil {
+		return localLimits.Take(key, limit, now), true
+	}
+	decision, err := sharedLimits.Take(key, limit, now)
+	if err != nil {
+		ctx.Logger().Printf("shared rate limit %s failed, request allowed: %s", key, err)
+		return decision, false
+	}
+	return decision, true
+}
+
+/**
+ * 依次检查一组规则,超限时返回429和false;放行时记录剩余最少的规则到tightest
+ */
+func takeLimits(ctx *fasthttp.RequestCtx, api *model.Api, scope string, limits []*model.RateLimit, now time.Time, tightest **rateLimitResult) bool {
+	for index, limit := range limits {
+		decision, ok := takeLimit(ctx, limitKey(ctx, scope, index, limit), limit, now)
+		if !ok {
+			continue
+		}
+		result := &rateLimitResult{limit: limit, decision: decision}
+		if !decision.Allowed {
+			writeError(ctx, api.ApiId, fasthttp.StatusTooManyRequests, ServiceApi.CODE_RATE_LIMITED, "rate limit exceeded")
+			setRateLimitHeaders(ctx, result)
+			ctx.Response.Header.Set("Retry-After", strconv.Itoa(seconds(decision.RetryAfter)))
+			return false
+		}
+		if *tightest == nil || decision.Remaining < (*tightest).decision.Remaining {
+			*tightest = result
+		}
+	}
+	return true
+}
+
+/**
+ * 重写前检查API和所属分组的限流规则,任一规则超限返回429;在认证之后执行,以便按调用方限流。
+ * 分组的规则按分组ID计数,分组内所有API共用限额
+ */
+func rateLimit(ctx *fasthttp.RequestCtx, rewrite *skyrewrite.SkyRewrite) bool {
+	api := rewrite.Api
+	if api == nil {
+		return true
+	}
+	group := rewrite.Group
+	if len(api.RateLimits) == 0 && (group == nil || len(group.RateLimits) == 0) {
+		return true
+	}
+
+	now := time.Now()
+	var tightest *rateLimitResult
+	if !takeLimits(ctx, api, strconv.Itoa(api.ApiId), api.RateLimits, now, &tightest) {
+		return false
+	}
+	if group != nil && !takeLimits(ctx, api, "g"+strconv.Itoa(group.GroupId), group.RateLimits, now, &tightest) {
+		return false
+	}
+	if tightest != nil {
+		ctx.SetUserValue(rateLimitKey, tightest)
+	}
+	return true
+}
+
+/**
+ * 时长向上取整为秒
+ */
+func seconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+/**
+ * 输出RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset和RateLimit-Policy响应头
+ */
+func setRateLimitHeaders(ctx *fasthttp.RequestCtx, result *rateLimitResult) {
+	decision := result.decision
+	ctx.Response.Header.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	ctx.Response.Header.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	ctx.Response.Header.Set("RateLimit-Reset", strconv.Itoa(seconds(decision.Reset)))
+	window := time.Duration(result.limit.Window) * time.Millisecond
+	ctx.Response.Header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.limit.Limit, seconds(window)))
+}
+
+/**
+ * 转发完成后输出放行时的限流响应头,后端响应会覆盖转发前设置的响应头
+ */
+func writeRateLimitHeaders(ctx *fasthttp.RequestCtx) {
+	if result, ok := ctx.UserValue(rateLimitKey).(*rateLimitResult); ok {
+		setRateLimitHeaders(ctx, result)
+	}
+}
@@ -2,18 +2,20 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"github.com/valyala/fasthttp"
 	"log"
+	"skyway/gateway/skyjwt"
+	"skyway/gateway/skylimit"
 	"skyway/gateway/skyrewrite"
-	"skyway/gateway/skyrouter"
+	"skyway/gateway/skyupstream"
+	"skyway/library"
+	"skyway/library/DataSource"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
 	"time"
 )
 
-var proxyClient = &fasthttp.HostClient{
-	Addr: "47.244.99.172:80",
-	// set other options here if required - most notably timeouts.
-}
-
 func prepareRequest(req *fasthttp.Request) {
 
 	log.Println("prepareRequest.....")
@@ -35,66 +37,128 @@ func postprocessResponse(resp *fasthttp.Response) {
 	// alter other response data if needed
 }
 
-func RouterRequest(ctx *fasthttp.RequestCtx, rewriteUri *skyrewrite.SkyRewrite) {
+func RouterRequest(ctx *fasthttp.RequestCtx, result *skyrewrite.RewriteResult) {
 	ctx.Logger().Printf("RewritedRequest原始URI:%s %s \n", ctx.Request.URI().Path(), ctx.Request.String())
-	ctx.Logger().Printf("RewritedRequest重写后URI:%s \n", rewriteUri.RewriteUri)
+	ctx.Logger().Printf("RewritedRequest重写后URI:%s \n", result.Uri)
+	reqId := requestId(ctx)
 
 	//重写URI
-	ctx.URI().SetPath(rewriteUri.RewriteUri)
-	ctx.Request.Header.SetRequestURI(rewriteUri.RewriteUri)
+	ctx.URI().SetPath(result.Uri)
+	ctx.Request.Header.SetRequestURI(result.Uri)
 
 	//重写QueryString
-	if len(rewriteUri.RewriteQueryString) > 0 {
+	if len(result.QueryString) > 0 {
 		var buffer bytes.Buffer
 		if len(ctx.URI().QueryString()) > 0 {
 			buffer.Write(ctx.URI().QueryString())
 			buffer.WriteByte('&')
 		}
-		buffer.WriteString(rewriteUri.RewriteQueryString)
+		buffer.WriteString(result.QueryString)
 		ctx.URI().SetQueryString(buffer.String())
 	}
 
+	upstream := currentUpstreams().Get(result.Rewrite.ServiceId)
+	if upstream == nil {
+		ctx.Logger().Printf("no upstream service %d for api %d", result.Rewrite.ServiceId, result.Rewrite.ApiId)
+		writeError(ctx, result.Rewrite.ApiId, fasthttp.StatusBadGateway, ServiceApi.CODE_NO_UPSTREAM_SERVICE, "no upstream service")
+		return
+	}
+	api := result.Rewrite.Api
+	apiCircuit := apiBreaker(api)
+	if apiCircuit != nil && !apiCircuit.Allow() {
+		ctx.Logger().Printf("circuit of api %d is open", api.ApiId)
+		writeFallback(ctx, api)
+		return
+	}
+
 	start := time.Now()
 	req := &ctx.Request
 	resp := &ctx.Response
 	prepareRequest(req)
-	if err := proxyClient.Do(req, resp); err != nil {
-		ctx.Logger().Printf("error when proxying the request: %s", err)
+	err := proxyRequest(ctx, api, upstream)
+	if apiCircuit != nil {
+		apiCircuit.Record(err == nil && resp.StatusCode() < fasthttp.StatusInternalServerError, time.Since(start))
+	}
+	switch err {
+	case nil:
+	case errCircuitOpen:
+		ctx.Logger().Printf("circuits of all targets in upstream service %d are open", upstream.ServiceId)
+		writeFallback(ctx, api)
+		return
+	default:
+		ctx.Logger().Printf("error when proxying the request %s: %s", reqId, err)
+		writeProxyError(ctx, result.Rewrite.ApiId, err)
+		return
 	}
 
 	postprocessResponse(resp)
+	resp.Header.Set(requestIdHeader, reqId)
+	writeRateLimitHeaders(ctx)
 	cost := time.Since(start).Nanoseconds() / 1e6
 	ctx.Logger().Printf("Response Cost:%d MS,Status=%d,[%s],\n", cost, resp.StatusCode(), resp.Header.Header())
 }
 
 func main() {
-	router := skyrouter.New()
-	a := skyrewrite.New()
-	a.OriginUri = "/hello/{name}/test/{foo}"
-	a.DestUri = "/test.php?hello=$1&test=$2"
-	a.ApiId = 1000
-
-	b := skyrewrite.New()
-	b.OriginUri = "/test/{path}"
-	b.DestUri = "/hello/test/$1"
-	b.ApiId = 1001
-
-	c := skyrewrite.New()
-	c.OriginUri = "/user/{id}/{age}?addr={addr}"
-	c.DestUri = "/user/$1/age/$2/addr/$3"
-	c.ApiId = 1003
-
-	d := skyrewrite.New()
-	d.OriginUri = "/foo/bar"
-	d.DestUri = "/v1/bar/foo"
-	d.ApiId = 1004
-
-	router.GET(a.OriginUri, a)
-	router.GET(b.OriginUri, b)
-	router.GET(c.OriginUri, c)
-	router.GET(d.OriginUri, d)
+	flag.Parse()
+	if !model.IsEnvironment(*environment) {
+		log.Fatalf("Unknown environment %s", *environment)
+	}
+	log.Printf("serving environment %s", *environment)
+	jwksFiles = skyjwt.NewFileKeySets(*jwksDir)
+
+	client := DataSource.GetInstance()
+	if client == nil {
+		log.Fatalf("Error in connect etcd")
+	}
+	sharedLimits = skylimit.NewEtcdStore(client)
+
+	services, err := loadServices(client)
+	if err != nil {
+		log.Fatalf("Error in load services: %s", err)
+	}
+	upstreams.Store(skyupstream.NewRegistry(services, nil))
+
+	index, err := loadConsumers(client)
+	if err != nil {
+		log.Fatalf("Error in load consumers: %s", err)
+	}
+	consumers.Store(index)
+	sets, err := loadJwks(client)
+	if err != nil {
+		log.Fatalf("Error in load jwks: %s", err)
+	}
+	jwksSets.Store(sets)
+
+	router, apis, err := loadRouter(client)
+	if err != nil {
+		log.Fatalf("Error in load apis: %s", err)
+	}
+	routedApis.Store(apis)
+	jwtStaticKeys.Store(loadJwtKeys(apis))
 
+	router.FilterHandle(authenticate)
+	router.FilterHandle(rateLimit)
 	router.RewriteHandle(RouterRequest)
+	router.NotFound = NotFound
+	router.MethodNotAllowed = MethodNotAllowed
+	go watchPrefix(client, DAO.SERVICE_PREFIX, func() {
+		reloadUpstreams(client)
+	})
+	go watchPrefix(client, DAO.PublishedApiPrefix(*environment), func() {
+		reloadRouter(client, router)
+	})
+	//分组修改只影响升级前发布,没有分组快照的API
+	go watchPrefix(client, DAO.GROUP_PREFIX, func() {
+		reloadRouter(client, router)
+	})
+	go watchPrefix(client, DAO.CONSUMER_PREFIX, func() {
+		reloadConsumers(client)
+	})
+	go watchPrefix(client, DAO.JWKS_PREFIX, func() {
+		reloadJwks(client)
+	})
+
+	go serveAdmin(":8889")
 
 	httpServer := fasthttp.Server{
 		Handler: router.Handler,
@@ -0,0 +1,319 @@
+package skybreaker
+
+import (
+	"log"
+	"skyway/managerapi/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+const (
+	defaultWindow           = 10000
+	defaultMinRequests      = 20
+	defaultOpenTime         = 30000
+	defaultHalfOpenRequests = 5
+
+	//统计窗口切分的桶数
+	buckets = 10
+)
+
+/**
+ * 补全熔断配置默认值
+ */
+func Settings(config *model.CircuitBreaker) model.CircuitBreaker {
+	settings := *config
+	if settings.Window <= 0 {
+		settings.Window = defaultWindow
+	}
+	if settings.MinRequests <= 0 {
+		settings.MinRequests = defaultMinRequests
+	}
+	if settings.OpenTime <= 0 {
+		settings.OpenTime = defaultOpenTime
+	}
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	return settings
+}
+
+type bucket struct {
+	start    int64
+	requests int
+	errors   int
+	slow     int
+}
+
+/**
+ * 熔断器: closed统计滑动窗口内的错误率和慢调用比例,超过阈值进入open;
+ * open持续OpenTime后进入half-open,放行HalfOpenRequests个探测请求,
+ * 全部成功恢复closed,任意失败重新open
+ */
+type Breaker struct {
+	name     string
+	settings model.CircuitBreaker
+
+	mu       sync.Mutex
+	state    State
+	buckets  [buckets]bucket
+	openedAt time.Time
+
+	//half-open状态下已放行和已成功的探测数
+	probes    int
+	successes int
+}
+
+func New(name string, config *model.CircuitBreaker) *Breaker {
+	return &Breaker{
+		name:     name,
+		settings: Settings(config),
+	}
+}
+
+/**
+ * 当前状态,open到期后视为half-open
+ */
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	return b.state
+}
+
+/**
+ * 是否可以放行请求,不占用half-open探测名额,用于挑选节点
+ */
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return b.probes < b.settings.HalfOpenRequests
+	}
+	return true
+}
+
+/**
+ * 请求发出前调用,返回false时应直接返回熔断响应;返回true时必须调用Record
+ */
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probes >= b.settings.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+/**
+ * 记录一次请求结果
+ */
+func (b *Breaker) Record(success bool, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.expire(now)
+
+	slow := b.settings.SlowCallDuration > 0 &&
+		latency >= time.Duration(b.settings.SlowCallDuration)*time.Millisecond
+
+	switch b.state {
+	case StateHalfOpen:
+		if !success || slow {
+			b.open(now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.settings.HalfOpenRequests {
+			b.close()
+		}
+	case StateClosed:
+		current := b.bucket(now)
+		current.requests++
+		if !success {
+			current.errors++
+		}
+		if slow {
+			current.slow++
+		}
+		if b.tripped(now) {
+			b.open(now)
+		}
+	}
+}
+
+/**
+ * 获取当前时间所在的桶,过期的桶清零后复用
+ */
+func (b *Breaker) bucket(now time.Time) *bucket {
+	size := int64(b.settings.Window) * int64(time.Millisecond) / buckets
+	if size <= 0 {
+		size = 1
+	}
+	start := now.UnixNano() / size * size
+	current := &b.buckets[(start/size)%buckets]
+	if current.start != start {
+		*current = bucket{start: start}
+	}
+	return current
+}
+
+/**
+ * 窗口内是否达到熔断条件
+ */
+func (b *Breaker) tripped(now time.Time) bool {
+	since := now.UnixNano() - int64(b.settings.Window)*int64(time.Millisecond)
+	requests, errors, slow := 0, 0, 0
+	for _, item := range b.buckets {
+		if item.start > since {
+			requests += item.requests
+			errors += item.errors
+			slow += item.slow
+		}
+	}
+	if requests < b.settings.MinRequests {
+		return false
+	}
+	if b.settings.ErrorRate > 0 && errors*100 >= b.settings.ErrorRate*requests {
+		return true
+	}
+	if b.settings.SlowCallDuration > 0 && b.settings.SlowCallRate > 0 && slow*100 >= b.settings.SlowCallRate*requests {
+		return true
+	}
+	return false
+}
+
+func (b *Breaker) open(now time.Time) {
+	if b.state != StateOpen {
+		log.Printf("circuit %s open", b.name)
+	}
+	b.state = StateOpen
+	b.openedAt = now
+	b.probes = 0
+	b.successes = 0
+}
+
+func (b *Breaker) close() {
+	log.Printf("circuit %s closed", b.name)
+	b.state = StateClosed
+	b.buckets = [buckets]bucket{}
+	b.probes = 0
+	b.successes = 0
+}
+
+/**
+ * open到期后转为half-open
+ */
+func (b *Breaker) expire(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= time.Duration(b.settings.OpenTime)*time.Millisecond {
+		log.Printf("circuit %s half-open", b.name)
+		b.state = StateHalfOpen
+		b.probes = 0
+		b.successes = 0
+	}
+}
+
+/**
+ * 熔断器集合,按名称索引,跨路由重新加载保留状态;配置变化时重建
+ */
+type Group struct {
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewGroup() *Group {
+	return &Group{
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+/**
+ * 获取指定名称的熔断器,config为空时返回nil
+ */
+func (g *Group) Get(name string, config *model.CircuitBreaker) *Breaker {
+	if config == nil {
+		return nil
+	}
+
+	settings := Settings(config)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	breaker := g.breakers[name]
+	if breaker == nil || breaker.settings != settings {
+		breaker = New(name, config)
+		g.breakers[name] = breaker
+	}
+	return breaker
+}
+
+/**
+ * 只保留names中的熔断器,已删除的API和已下线的节点不再占用内存
+ */
+func (g *Group) Retain(names map[string]bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name := range g.breakers {
+		if !names[name] {
+			delete(g.breakers, name)
+		}
+	}
+}
+
+/**
+ * 熔断器状态,用于管理接口展示
+ */
+type BreakerStatus struct {
+	Name  string
+	State string
+}
+
+func (g *Group) Status() []*BreakerStatus {
+	g.mu.Lock()
+	breakers := make([]*Breaker, 0, len(g.breakers))
+	for _, breaker := range g.breakers {
+		breakers = append(breakers, breaker)
+	}
+	g.mu.Unlock()
+
+	status := make([]*BreakerStatus, 0, len(breakers))
+	for _, breaker := range breakers {
+		status = append(status, &BreakerStatus{
+			Name:  breaker.name,
+			State: breaker.State().String(),
+		})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+	return status
+}
@@ -0,0 +1,47 @@
+package skyhmac
+
+import (
+	"sync"
+	"time"
+)
+
+// 每次写入时最多清理的过期nonce数,避免单个请求耗时过长
+const sweepLimit = 64
+
+/**
+ * 已使用的nonce,保留到时间戳超出允许偏差为止,用于拒绝重放请求
+ */
+type NonceCache struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewNonceCache() *NonceCache {
+	return &NonceCache{
+		nonces: make(map[string]time.Time),
+	}
+}
+
+/**
+ * 记录nonce,expires之后可被清理;nonce未过期且已使用过时返回false
+ */
+func (cache *NonceCache) Use(nonce string, expires time.Time, now time.Time) bool {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if used, ok := cache.nonces[nonce]; ok && now.Before(used) {
+		return false
+	}
+	swept := 0
+	for key, used := range cache.nonces {
+		if swept >= sweepLimit {
+			break
+		}
+		if !now.Before(used) {
+			delete(cache.nonces, key)
+		}
+		swept++
+	}
+	cache.nonces[nonce] = expires
+	return true
+}
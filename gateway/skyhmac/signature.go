@@ -0,0 +1,81 @@
+package skyhmac
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/**
+ * 签名相关的请求头
+ */
+const (
+	HEADER_KEY_ID    = "X-Hmac-Key-Id"
+	HEADER_TIMESTAMP = "X-Hmac-Timestamp"
+	HEADER_NONCE     = "X-Hmac-Nonce"
+	HEADER_SIGNATURE = "X-Hmac-Signature"
+)
+
+/**
+ * QueryString参数
+ */
+type Param struct {
+	Key   string
+	Value string
+}
+
+/**
+ * 参数按名称,再按取值排序,转义后以&连接
+ */
+func SortedQuery(params []Param) string {
+	sorted := make([]Param, len(params))
+	copy(sorted, params)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Key != sorted[j].Key {
+			return sorted[i].Key < sorted[j].Key
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+
+	pairs := make([]string, 0, len(sorted))
+	for _, param := range sorted {
+		pairs = append(pairs, url.QueryEscape(param.Key)+"="+url.QueryEscape(param.Value))
+	}
+	return strings.Join(pairs, "&")
+}
+
+/**
+ * 待签名字符串,各项以换行连接:
+ * 请求方法,原始路径,排序后的QueryString,时间戳(Unix秒),nonce,请求体SHA256(小写hex)
+ */
+func Canonical(method string, path string, params []Param, timestamp string, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		SortedQuery(params),
+		timestamp,
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "\n")
+}
+
+/**
+ * 计算签名,HMAC-SHA256后小写hex
+ */
+func Sign(secret string, canonical string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * 常数时间比较签名
+ */
+func Verify(secret string, canonical string, signature string) bool {
+	expected := Sign(secret, canonical)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
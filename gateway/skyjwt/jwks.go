@@ -0,0 +1,245 @@
+package skyjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	jsoniter "github.com/json-iterator/go"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// 本地JWKS文件的检查间隔,期间不重复stat
+const fileCheckInterval = time.Second
+
+/**
+ * 验证签名的密钥,Key为[]byte,*rsa.PublicKey或*ecdsa.PublicKey
+ */
+type Key struct {
+	Kid string
+	Alg string
+	Key interface{}
+}
+
+/**
+ * 一组密钥,对应一个JWKS
+ */
+type KeySet struct {
+	Keys []*Key
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func parseJwk(item *jwk) (*Key, error) {
+	key := &Key{Kid: item.Kid, Alg: item.Alg}
+	switch item.Kty {
+	case "RSA":
+		n, err := decodeBigInt(item.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %s", err)
+		}
+		e, err := decodeBigInt(item.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %s", err)
+		}
+		if n.Sign() == 0 || !e.IsInt64() || e.Int64() < 3 {
+			return nil, fmt.Errorf("invalid rsa key")
+		}
+		key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch item.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", item.Crv)
+		}
+		x, err := decodeBigInt(item.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %s", err)
+		}
+		y, err := decodeBigInt(item.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %s", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", item.Crv)
+		}
+		key.Key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(item.K)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("invalid k")
+		}
+		key.Key = secret
+	default:
+		return nil, fmt.Errorf("unsupported kty '%s'", item.Kty)
+	}
+	return key, nil
+}
+
+/**
+ * 解析JWKS,只保留用于验证签名的密钥
+ */
+func ParseJwks(data []byte) (*KeySet, error) {
+	var jwks struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %s", err)
+	}
+
+	set := &KeySet{}
+	for i, item := range jwks.Keys {
+		if item.Use != "" && item.Use != "sig" {
+			continue
+		}
+		key, err := parseJwk(item)
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %s", i, err)
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	return set, nil
+}
+
+// 已解析的PEM公钥,同一公钥被多个API共用
+var publicKeys sync.Map
+
+/**
+ * 解析PEM格式的RSA或EC公钥
+ */
+func ParsePublicKey(pem string) (*Key, error) {
+	if key, ok := publicKeys.Load(pem); ok {
+		return key.(*Key), nil
+	}
+	var parsed interface{}
+	if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem)); err == nil {
+		parsed = rsaKey
+	} else if ecKey, err := jwt.ParseECPublicKeyFromPEM([]byte(pem)); err == nil {
+		parsed = ecKey
+	} else {
+		return nil, fmt.Errorf("invalid public key, expect PEM encoded RSA or EC public key")
+	}
+	key := &Key{Key: parsed}
+	publicKeys.Store(pem, key)
+	return key, nil
+}
+
+/**
+ * HMAC共享密钥
+ */
+func SecretKey(secret string) *Key {
+	return &Key{Key: []byte(secret)}
+}
+
+type fileEntry struct {
+	modTime   time.Time
+	checkedAt time.Time
+	set       *KeySet
+	err       error
+}
+
+/**
+ * 本地JWKS文件,只能读取目录dir下的文件,按修改时间自动重新加载
+ */
+type FileKeySets struct {
+	dir   string
+	mu    sync.Mutex
+	files map[string]*fileEntry
+}
+
+/**
+ * dir为空时不允许读取本地JWKS文件
+ */
+func NewFileKeySets(dir string) *FileKeySets {
+	return &FileKeySets{
+		dir:   dir,
+		files: make(map[string]*fileEntry),
+	}
+}
+
+/**
+ * JWKS文件名是否合法: 只能是文件名,不能包含路径
+ */
+func IsFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+/**
+ * 获取目录中名为name的JWKS文件中的密钥,文件修改后重新解析;解析失败时返回错误
+ */
+func (sets *FileKeySets) Get(name string) (*KeySet, error) {
+	if sets.dir == "" {
+		return nil, fmt.Errorf("jwks file %s: gateway jwks directory is not configured", name)
+	}
+	if !IsFileName(name) {
+		return nil, fmt.Errorf("jwks file %s: must be a file name in the jwks directory", name)
+	}
+	path := filepath.Join(sets.dir, name)
+
+	now := time.Now()
+	sets.mu.Lock()
+	defer sets.mu.Unlock()
+
+	entry := sets.files[path]
+	if entry != nil && now.Sub(entry.checkedAt) < fileCheckInterval {
+		return entry.set, entry.err
+	}
+	if entry == nil {
+		entry = &fileEntry{}
+		sets.files[path] = entry
+	}
+	entry.checkedAt = now
+
+	info, err := os.Stat(path)
+	if err != nil {
+		entry.set, entry.err = nil, err
+		return nil, err
+	}
+	if entry.set != nil && info.ModTime().Equal(entry.modTime) {
+		return entry.set, nil
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		entry.set, err = ParseJwks(data)
+	}
+	entry.modTime = info.ModTime()
+	entry.err = err
+	if err != nil {
+		entry.set = nil
+	}
+	return entry.set, err
+}
@@ -0,0 +1,217 @@
+package skyjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"skyway/managerapi/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Token格式错误,签名不正确,已过期或iss,aud不匹配
+	ErrInvalidToken = errors.New("invalid token")
+	// Token合法,但缺少要求的claim
+	ErrInsufficientClaims = errors.New("insufficient claims")
+)
+
+// 支持的签名算法
+var Algorithms = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+/**
+ * 是否为支持的签名算法
+ */
+func IsAlgorithm(alg string) bool {
+	for _, supported := range Algorithms {
+		if supported == alg {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 算法与密钥类型是否匹配,避免用公钥作为HMAC密钥等算法混淆
+ */
+func compatible(alg string, key interface{}) bool {
+	switch key.(type) {
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES")
+	}
+	return false
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidToken, fmt.Sprintf(format, args...))
+}
+
+/**
+ * 读取exp,nbf等数字claim,不存在时ok为false
+ */
+func numericClaim(claims jwt.MapClaims, name string) (float64, bool, error) {
+	value, exists := claims[name]
+	if !exists {
+		return 0, false, nil
+	}
+	switch number := value.(type) {
+	case float64:
+		return number, true, nil
+	case stdjson.Number:
+		n, err := number.Float64()
+		if err != nil {
+			return 0, false, invalid("%s is not a number", name)
+		}
+		return n, true, nil
+	}
+	return 0, false, invalid("%s is not a number", name)
+}
+
+/**
+ * claim转为字符串,数组以空格连接,用于比较取值和转发给后端
+ */
+func ClaimString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case stdjson.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, ClaimString(item))
+		}
+		return strings.Join(items, " ")
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+/**
+ * claim的全部取值,字符串按空格拆分,如scope
+ */
+func claimValues(value interface{}) []string {
+	if items, ok := value.([]interface{}); ok {
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			values = append(values, ClaimString(item))
+		}
+		return values
+	}
+	return strings.Fields(ClaimString(value))
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 用keys中的密钥验证Token签名,并按policy检查exp,nbf,iss,aud和要求的claim;
+ * 失败时返回的错误包装ErrInvalidToken或ErrInsufficientClaims
+ */
+func Verify(token string, policy *model.JwtPolicy, keys []*Key, now time.Time) (jwt.MapClaims, error) {
+	parser := &jwt.Parser{UseJSONNumber: true}
+	claims := jwt.MapClaims{}
+	parsed, parts, err := parser.ParseUnverified(token, claims)
+	if err != nil {
+		return nil, invalid("%s", err)
+	}
+
+	alg := parsed.Method.Alg()
+	allowed := policy.Algorithms
+	if len(allowed) == 0 {
+		allowed = Algorithms
+	}
+	if !contains(allowed, alg) {
+		return nil, invalid("algorithm %s is not allowed", alg)
+	}
+
+	kid, _ := parsed.Header["kid"].(string)
+	signingString := parts[0] + "." + parts[1]
+	verified := false
+	for _, key := range keys {
+		if key.Alg != "" && key.Alg != alg {
+			continue
+		}
+		if kid != "" && key.Kid != "" && key.Kid != kid {
+			continue
+		}
+		if !compatible(alg, key.Key) {
+			continue
+		}
+		if parsed.Method.Verify(signingString, parts[2], key.Key) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, invalid("signature verification failed")
+	}
+
+	leeway := time.Duration(policy.Leeway) * time.Millisecond
+	exp, ok, err := numericClaim(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if !ok && !policy.AllowMissingExp {
+		return nil, invalid("token has no exp")
+	}
+	if ok && now.Add(-leeway).After(time.Unix(int64(exp), 0)) {
+		return nil, invalid("token is expired")
+	}
+	nbf, ok, err := numericClaim(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, invalid("token is not valid yet")
+	}
+	if policy.Issuer != "" && ClaimString(claims["iss"]) != policy.Issuer {
+		return nil, invalid("issuer mismatch")
+	}
+	if len(policy.Audiences) > 0 {
+		matched := false
+		for _, aud := range claimValues(claims["aud"]) {
+			if contains(policy.Audiences, aud) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, invalid("audience mismatch")
+		}
+	}
+
+	for name, required := range policy.RequiredClaims {
+		value, exists := claims[name]
+		if !exists {
+			return nil, fmt.Errorf("%w: missing claim %s", ErrInsufficientClaims, name)
+		}
+		values := claimValues(value)
+		for _, item := range strings.Fields(required) {
+			if !contains(values, item) {
+				return nil, fmt.Errorf("%w: claim %s must contain %s", ErrInsufficientClaims, name, item)
+			}
+		}
+	}
+	return claims, nil
+}
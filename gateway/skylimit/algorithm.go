@@ -0,0 +1,166 @@
+package skylimit
+
+import (
+	"math"
+	"skyway/managerapi/model"
+	"time"
+)
+
+/**
+ * 单个限流计数的状态,本地和etcd共享模式使用同一结构;时间为Unix毫秒
+ */
+type State struct {
+	//token-bucket: 剩余令牌数和上次补充时间
+	Tokens float64
+	Last   int64
+	//sliding-window: 当前窗口的起始时间,当前窗口和上一窗口的请求数
+	WindowStart int64
+	Count       int
+	PrevCount   int
+}
+
+/**
+ * 一次限流判断的结果
+ */
+type Decision struct {
+	Allowed bool
+	//窗口内的限额
+	Limit int
+	//剩余可用请求数
+	Remaining int
+	//限额完全恢复的剩余时间
+	Reset time.Duration
+	//被限流时建议的重试等待时间
+	RetryAfter time.Duration
+}
+
+func millis(ms float64) time.Duration {
+	return time.Duration(math.Ceil(ms)) * time.Millisecond
+}
+
+/**
+ * 规则的Limit和Window都大于0才有效,否则计算时会除零
+ */
+func Valid(limit *model.RateLimit) bool {
+	return limit.Limit > 0 && limit.Window > 0
+}
+
+/**
+ * 令牌桶容量,未配置Burst时等于Limit
+ */
+func capacity(limit *model.RateLimit) int {
+	if limit.Burst > 0 {
+		return limit.Burst
+	}
+	return limit.Limit
+}
+
+/**
+ * 计数空闲后可以丢弃的时间: 令牌桶补满或滑动窗口的两个窗口都已过去
+ */
+func idleTime(limit *model.RateLimit) time.Duration {
+	window := time.Duration(limit.Window) * time.Millisecond
+	if limit.Algorithm == model.RATE_LIMIT_TOKEN_BUCKET {
+		refill := window * time.Duration(capacity(limit)) / time.Duration(limit.Limit)
+		if refill > 2*window {
+			return refill
+		}
+	}
+	return 2 * window
+}
+
+/**
+ * 按规则消耗一次请求,更新state并返回判断结果
+ */
+func Take(limit *model.RateLimit, state *State, now time.Time) Decision {
+	//加载路由时已丢弃无效规则,这里防御性地不限流
+	if !Valid(limit) {
+		return Decision{Allowed: true}
+	}
+	if limit.Algorithm == model.RATE_LIMIT_SLIDING_WINDOW {
+		return slidingWindow(limit, state, now.UnixNano()/int64(time.Millisecond))
+	}
+	return tokenBucket(limit, state, now.UnixNano()/int64(time.Millisecond))
+}
+
+func tokenBucket(limit *model.RateLimit, state *State, now int64) Decision {
+	burst := float64(capacity(limit))
+	//每毫秒补充的令牌数
+	rate := float64(limit.Limit) / float64(limit.Window)
+
+	if state.Last == 0 {
+		state.Tokens = burst
+	} else if now > state.Last {
+		state.Tokens = math.Min(burst, state.Tokens+float64(now-state.Last)*rate)
+	}
+	if now > state.Last {
+		state.Last = now
+	}
+
+	decision := Decision{Limit: capacity(limit)}
+	if state.Tokens >= 1 {
+		state.Tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = millis((1 - state.Tokens) / rate)
+	}
+	decision.Remaining = int(math.Floor(state.Tokens))
+	decision.Reset = millis((burst - state.Tokens) / rate)
+	return decision
+}
+
+/**
+ * 滑动窗口计数: 上一窗口的请求数按未过去的比例计入当前窗口
+ */
+func slidingWindow(limit *model.RateLimit, state *State, now int64) Decision {
+	window := int64(limit.Window)
+	start := now - now%window
+	if state.WindowStart != start {
+		if state.WindowStart == start-window {
+			state.PrevCount = state.Count
+		} else {
+			state.PrevCount = 0
+		}
+		state.Count = 0
+		state.WindowStart = start
+	}
+
+	elapsed := float64(now - start)
+	max := float64(limit.Limit)
+	prev := float64(state.PrevCount)
+	estimate := prev*(1-elapsed/float64(window)) + float64(state.Count)
+
+	decision := Decision{Limit: limit.Limit}
+	if estimate+1 <= max {
+		state.Count++
+		estimate++
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = retryAfter(state, max, float64(window), elapsed)
+	}
+	decision.Remaining = int(math.Max(0, math.Floor(max-estimate)))
+	decision.Reset = millis(float64(window) - elapsed)
+	//被限流时窗口结束后估算值可能仍超限,重置时间不早于重试时间
+	if decision.Reset < decision.RetryAfter {
+		decision.Reset = decision.RetryAfter
+	}
+	return decision
+}
+
+/**
+ * 被限流后,估算的请求数降到可以再放行一个请求所需的时间
+ */
+func retryAfter(state *State, max float64, window float64, elapsed float64) time.Duration {
+	count := float64(state.Count)
+	if count+1 <= max && state.PrevCount > 0 {
+		//当前窗口内等待上一窗口的权重下降
+		wait := window*(1-(max-count-1)/float64(state.PrevCount)) - elapsed
+		return millis(math.Max(wait, 1))
+	}
+	//当前窗口已满,等到下一窗口,当前窗口的请求数成为上一窗口
+	wait := window - elapsed
+	if count > 0 {
+		wait += math.Max(0, window*(1-(max-1)/count))
+	}
+	return millis(math.Max(wait, 1))
+}
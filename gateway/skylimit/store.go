@@ -0,0 +1,231 @@
+package skylimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/coreos/etcd/clientv3"
+	jsoniter "github.com/json-iterator/go"
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+	"sync"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	//etcd共享模式的key前缀
+	SHARED_PREFIX = "RATELIMIT_"
+
+	//每次写入时最多清理的过期计数
+	sweepLimit = 64
+	//共享模式并发写入冲突时的最大重试次数,仍然冲突时按超限处理
+	maxAttempts = 5
+	//冲突过多被拒绝时建议的重试等待时间
+	contentionRetryAfter = time.Second
+)
+
+type localEntry struct {
+	state   State
+	expires time.Time
+}
+
+/**
+ * 本地限流计数,只在当前网关实例内有效
+ */
+type LocalStore struct {
+	mu      sync.Mutex
+	entries map[string]*localEntry
+}
+
+func NewLocalStore() *LocalStore {
+	return &LocalStore{
+		entries: make(map[string]*localEntry),
+	}
+}
+
+/**
+ * 对key消耗一次请求
+ */
+func (store *LocalStore) Take(key string, limit *model.RateLimit, now time.Time) Decision {
+	if !Valid(limit) {
+		return Take(limit, nil, now)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry := store.entries[key]
+	if entry == nil {
+		swept := 0
+		for other, cached := range store.entries {
+			if swept >= sweepLimit {
+				break
+			}
+			if !now.Before(cached.expires) {
+				delete(store.entries, other)
+			}
+			swept++
+		}
+		entry = &localEntry{}
+		store.entries[key] = entry
+	}
+	decision := Take(limit, &entry.state, now)
+	entry.expires = now.Add(idleTime(limit))
+	return decision
+}
+
+type lease struct {
+	id        clientv3.LeaseID
+	grantedAt time.Time
+}
+
+/**
+ * 本实例最近一次读到或写入的共享计数及其版本号
+ */
+type sharedEntry struct {
+	state    State
+	revision int64
+	expires  time.Time
+}
+
+/**
+ * 保存在etcd中的限流计数,多个网关实例共享;以本地缓存的状态和版本号直接CAS写回,
+ * 冲突时用事务返回的最新值重试,无冲突时每次判断只需一次etcd请求;
+ * 计数绑定租约,空闲后由etcd删除
+ */
+type EtcdStore struct {
+	client *DataSource.EtcdClient
+
+	leaseMu sync.Mutex
+	leases  map[int64]*lease
+
+	mu      sync.Mutex
+	entries map[string]*sharedEntry
+}
+
+func NewEtcdStore(client *DataSource.EtcdClient) *EtcdStore {
+	return &EtcdStore{
+		client:  client,
+		leases:  make(map[int64]*lease),
+		entries: make(map[string]*sharedEntry),
+	}
+}
+
+/**
+ * 获取ttl秒的租约;同一ttl的计数共用租约,过半后重新申请,保证最后一次写入后至少保留ttl/2
+ */
+func (store *EtcdStore) lease(ttl int64, now time.Time) (clientv3.LeaseID, error) {
+	store.leaseMu.Lock()
+	defer store.leaseMu.Unlock()
+
+	current := store.leases[ttl]
+	if current != nil && now.Sub(current.grantedAt) < time.Duration(ttl)*time.Second/2 {
+		return current.id, nil
+	}
+	id, err := store.client.Grant(ttl)
+	if err != nil {
+		return 0, err
+	}
+	store.leases[ttl] = &lease{id: id, grantedAt: now}
+	return id, nil
+}
+
+/**
+ * 本地缓存的计数,没有或已空闲过期时返回空状态和版本号0
+ */
+func (store *EtcdStore) cached(etcdKey string, now time.Time) (State, int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry := store.entries[etcdKey]
+	if entry == nil || !now.Before(entry.expires) {
+		return State{}, 0
+	}
+	return entry.state, entry.revision
+}
+
+/**
+ * 缓存计数,空闲时间后过期
+ */
+func (store *EtcdStore) remember(etcdKey string, state State, revision int64, limit *model.RateLimit, now time.Time) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry := store.entries[etcdKey]
+	if entry == nil {
+		swept := 0
+		for other, cached := range store.entries {
+			if swept >= sweepLimit {
+				break
+			}
+			if !now.Before(cached.expires) {
+				delete(store.entries, other)
+			}
+			swept++
+		}
+		entry = &sharedEntry{}
+		store.entries[etcdKey] = entry
+	}
+	entry.state, entry.revision, entry.expires = state, revision, now.Add(idleTime(limit))
+}
+
+/**
+ * 对key消耗一次请求,只在etcd不可用时返回error。
+ * 其他实例只会消耗限额,本地缓存的状态已经超限时实际一定超限,直接拒绝不访问etcd;
+ * 并发冲突超过重试次数时按超限拒绝,避免限流在高并发下失效
+ */
+func (store *EtcdStore) Take(key string, limit *model.RateLimit, now time.Time) (Decision, error) {
+	if !Valid(limit) {
+		return Take(limit, nil, now), nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	etcdKey := SHARED_PREFIX + hex.EncodeToString(sum[:16])
+
+	state, revision := store.cached(etcdKey, now)
+	if revision > 0 {
+		stale := state
+		if decision := Take(limit, &stale, now); !decision.Allowed {
+			return decision, nil
+		}
+	}
+
+	//租约时长取空闲时间的两倍,向上取整到秒
+	ttl := int64(2*idleTime(limit)/time.Second) + 1
+	leaseId, err := store.lease(ttl, now)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		next := state
+		decision := Take(limit, &next, now)
+		if !decision.Allowed && revision > 0 {
+			//最新状态已超限,不需要写回
+			store.remember(etcdKey, state, revision, limit, now)
+			return decision, nil
+		}
+		data, err := json.MarshalToString(&next)
+		if err != nil {
+			return Decision{}, err
+		}
+		committed, value, current, err := store.client.CompareAndSwapWithLease(etcdKey, revision, data, leaseId)
+		if err != nil {
+			return Decision{}, err
+		}
+		if committed > 0 {
+			store.remember(etcdKey, next, committed, limit, now)
+			return decision, nil
+		}
+
+		//版本号不一致,使用事务返回的最新值重试;key已被删除时从空状态开始
+		state, revision = State{}, current
+		if value != "" {
+			if err := json.UnmarshalFromString(value, &state); err != nil {
+				state = State{}
+			}
+		}
+	}
+	return Decision{
+		Limit:      limit.Limit,
+		Reset:      contentionRetryAfter,
+		RetryAfter: contentionRetryAfter,
+	}, nil
+}
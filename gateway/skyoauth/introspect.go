@@ -0,0 +1,236 @@
+package skyoauth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+	"skyway/managerapi/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	defaultTimeout   = 3000
+	defaultCacheTime = 300000
+	//无效Token的结果只短暂缓存,避免大量随机Token占满缓存
+	inactiveCacheTime = 10000
+
+	//最多缓存的自省结果数,超出时淘汰最久未使用的
+	maxCacheEntries = 10000
+)
+
+/**
+ * 自省结果(RFC 7662),只解析网关用到的字段
+ */
+type Result struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope"`
+	ClientId string `json:"client_id"`
+	Username string `json:"username"`
+	Sub      string `json:"sub"`
+	Exp      int64  `json:"exp"`
+}
+
+/**
+ * Token的scope列表
+ */
+func (result *Result) Scopes() []string {
+	return strings.Fields(result.Scope)
+}
+
+/**
+ * 是否包含全部scope,返回缺少的第一个
+ */
+func (result *Result) HasScopes(required []string) (string, bool) {
+	scopes := result.Scopes()
+	for _, scope := range required {
+		found := false
+		for _, own := range scopes {
+			if own == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return scope, false
+		}
+	}
+	return "", true
+}
+
+type cacheEntry struct {
+	key     string
+	result  *Result
+	expires time.Time
+}
+
+/**
+ * 进行中的自省请求,同一Token的并发查询共用一次调用
+ */
+type pendingCall struct {
+	done   chan struct{}
+	result *Result
+	err    error
+}
+
+/**
+ * 令牌自省客户端,结果缓存到Token过期,不超过配置的最长缓存时间;
+ * 缓存按最近使用淘汰,数量不超过maxCacheEntries
+ */
+type Introspector struct {
+	client *fasthttp.Client
+
+	mu      sync.Mutex
+	cache   map[string]*list.Element
+	lru     *list.List
+	pending map[string]*pendingCall
+}
+
+func NewIntrospector() *Introspector {
+	return &Introspector{
+		client:  &fasthttp.Client{Name: "skyway"},
+		cache:   make(map[string]*list.Element),
+		lru:     list.New(),
+		pending: make(map[string]*pendingCall),
+	}
+}
+
+/**
+ * 缓存key包含自省地址和客户端,不同授权服务器的结果互不影响;不保存Token原文
+ */
+func cacheKey(policy *model.OAuth2Policy, token string) string {
+	sum := sha256.Sum256([]byte(policy.IntrospectionUrl + "\x00" + policy.ClientId + "\x00" + token))
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+ * 查询Token状态,优先使用缓存;同一Token的并发查询只调用一次授权服务器,调用失败时返回error
+ */
+func (introspector *Introspector) Introspect(policy *model.OAuth2Policy, token string) (*Result, error) {
+	key := cacheKey(policy, token)
+	introspector.mu.Lock()
+	if result, ok := introspector.lookup(key, time.Now()); ok {
+		introspector.mu.Unlock()
+		return result, nil
+	}
+	if call := introspector.pending[key]; call != nil {
+		introspector.mu.Unlock()
+		<-call.done
+		return call.result, call.err
+	}
+	call := &pendingCall{done: make(chan struct{})}
+	introspector.pending[key] = call
+	introspector.mu.Unlock()
+
+	call.result, call.err = introspector.call(policy, token)
+
+	introspector.mu.Lock()
+	delete(introspector.pending, key)
+	if call.err == nil {
+		introspector.store(key, call.result, policy, time.Now())
+	}
+	introspector.mu.Unlock()
+	close(call.done)
+	return call.result, call.err
+}
+
+/**
+ * 查找未过期的缓存结果并标记为最近使用,调用方持有锁
+ */
+func (introspector *Introspector) lookup(key string, now time.Time) (*Result, bool) {
+	element := introspector.cache[key]
+	if element == nil {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		introspector.lru.Remove(element)
+		delete(introspector.cache, key)
+		return nil, false
+	}
+	introspector.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+/**
+ * 缓存自省结果: 有效Token缓存到exp且不超过CacheTime,无效Token最多缓存inactiveCacheTime;
+ * 超出数量上限时淘汰最久未使用的结果,调用方持有锁
+ */
+func (introspector *Introspector) store(key string, result *Result, policy *model.OAuth2Policy, now time.Time) {
+	cacheTime := policy.CacheTime
+	if cacheTime <= 0 {
+		cacheTime = defaultCacheTime
+	}
+	if !result.Active && cacheTime > inactiveCacheTime {
+		cacheTime = inactiveCacheTime
+	}
+	expires := now.Add(time.Duration(cacheTime) * time.Millisecond)
+	if result.Active && result.Exp > 0 && time.Unix(result.Exp, 0).Before(expires) {
+		expires = time.Unix(result.Exp, 0)
+	}
+
+	entry := &cacheEntry{key: key, result: result, expires: expires}
+	if element := introspector.cache[key]; element != nil {
+		element.Value = entry
+		introspector.lru.MoveToFront(element)
+		return
+	}
+	introspector.cache[key] = introspector.lru.PushFront(entry)
+	for introspector.lru.Len() > maxCacheEntries {
+		oldest := introspector.lru.Back()
+		introspector.lru.Remove(oldest)
+		delete(introspector.cache, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+/**
+ * 调用自省接口: POST表单token,客户端凭证通过Basic认证发送
+ */
+func (introspector *Introspector) call(policy *model.OAuth2Policy, token string) (*Result, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(policy.IntrospectionUrl)
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if policy.ClientId != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(policy.ClientId + ":" + policy.ClientSecret))
+		req.Header.Set("Authorization", "Basic "+credentials)
+	}
+	args := fasthttp.AcquireArgs()
+	defer fasthttp.ReleaseArgs(args)
+	args.Set("token", token)
+	args.Set("token_type_hint", "access_token")
+	req.SetBody(args.QueryString())
+
+	timeout := policy.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if err := introspector.client.DoTimeout(req, resp, time.Duration(timeout)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode())
+	}
+
+	result := &Result{}
+	if err := json.Unmarshal(resp.Body(), result); err != nil {
+		return nil, fmt.Errorf("invalid introspection response: %s", err)
+	}
+	//已过期的Token按无效处理,不依赖授权服务器的判断
+	if result.Active && result.Exp > 0 && time.Now().After(time.Unix(result.Exp, 0)) {
+		result.Active = false
+	}
+	return result, nil
+}
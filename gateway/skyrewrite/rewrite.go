@@ -1,9 +1,11 @@
 package skyrewrite
 
 import (
+	"fmt"
 	"github.com/valyala/fasthttp"
 	"log"
 	"regexp"
+	"skyway/managerapi/model"
 	"strings"
 	"sync"
 )
@@ -11,12 +13,13 @@ import (
 type SkyRewrite struct {
 	//---/hello/foo1111/test/name2222
 	ApiId                    int    //所属API ID
+	ServiceId                int    //后端服务ID
+	Api                      *model.Api //API定义,只读
+	Group                    *model.Group //所属分组,只读,未分组时为nil
 	OriginUri                string //---/hello/{name}/test/{foo} uri参数表达式,用户设定
 	RouterPath               string //---/hello/:name/test/:foo 路由匹配,fastrouter
 	OriginReg                string //---/hello/(\w+)/test/(\w+)
 	DestUri                  string //---/test/$1/hello/$2   目标uri转换
-	RewriteUri               string
-	RewriteQueryString       string
 	Regexp                   *regexp.Regexp
 	IsMatchOriginQueryString bool
 	IsMatchDestQueryString   bool
@@ -30,7 +33,21 @@ func New() *SkyRewrite {
 	}
 }
 
-type RewriteHandler func(ctx *fasthttp.RequestCtx, rewrite *SkyRewrite)
+/**
+ * 单次请求的重写结果,每个请求独享;注册到路由树的SkyRewrite被所有请求共享,只读
+ */
+type RewriteResult struct {
+	Rewrite     *SkyRewrite //命中的重写规则
+	Uri         string      //重写后的URI
+	QueryString string      //重写后追加的QueryString
+}
+
+type RewriteHandler func(ctx *fasthttp.RequestCtx, result *RewriteResult)
+
+/**
+ * 重写前对命中的规则执行的检查,如认证;返回false表示已输出响应,请求不再继续
+ */
+type FilterHandler func(ctx *fasthttp.RequestCtx, rewrite *SkyRewrite) bool
 
 var instance *SkyRewrite
 var once sync.Once
@@ -45,8 +62,18 @@ func ApiRouterInstance() *SkyRewrite {
 /**
  {foo}形参转为正则表达式(\\w+)
  {foo}形参转为正则表达式:foo
+ OriginUri非法时panic,需要检查错误时使用Compile
  */
 func (api *SkyRewrite) MakeRegexp() {
+	if err := api.Compile(); err != nil {
+		panic(err)
+	}
+}
+
+/**
+ * 同MakeRegexp,OriginUri非法时返回错误
+ */
+func (api *SkyRewrite) Compile() error {
 
 	//带形参匹配
 	pos := strings.Index(api.OriginUri, "?")
@@ -58,6 +85,9 @@ func (api *SkyRewrite) MakeRegexp() {
 		params := strings.Split(queryStr, "&")
 		for _, pair := range params {
 			pairs := strings.Split(pair, "=")
+			if len(pairs) != 2 {
+				return fmt.Errorf("invalid query parameter '%s' in '%s'", pair, queryStr)
+			}
 
 			index := strings.IndexByte(pairs[1], '{')
 			if index == 0 {
@@ -86,8 +116,13 @@ func (api *SkyRewrite) MakeRegexp() {
 	regCompile := regexp.MustCompile(regstr)
 	api.OriginReg = regCompile.ReplaceAllString(api.OriginUri, `(\w+)`)
 	//r.DestReg = regCompile.ReplaceAllString(r.OriginUri, )
-	api.Regexp = regexp.MustCompile(api.OriginReg);
+	originRegexp, err := regexp.Compile(api.OriginReg)
+	if err != nil {
+		return err
+	}
+	api.Regexp = originRegexp
 	regpath := `\{(\w+)\}`
 	pathCompile := regexp.MustCompile(regpath)
 	api.RouterPath = pathCompile.ReplaceAllString(api.OriginUri, ":${1}")
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"skyway/gateway/skyrewrite"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 var (
@@ -19,6 +20,11 @@ var (
 type Router struct {
 	trees map[string]*node
 
+	// The trees read by Handler. Handle publishes r.trees here and Swap
+	// replaces it with the trees of another router in a single atomic store,
+	// so a request always walks one complete tree.
+	routes atomic.Value
+
 	// Enables automatic redirection if the current route can't be matched but a
 	// handler for the path with (without) the trailing slash exists.
 	// For example if /foo/ is requested but a route only exists for /foo, the
@@ -69,6 +75,10 @@ type Router struct {
 
 	// Rewrite and handle request
 	OnRequestFunc skyrewrite.RewriteHandler
+
+	// Checks run on the matched rewrite before RewriteRequest, e.g.
+	// authentication. A filter returning false has written the response.
+	Filters []skyrewrite.FilterHandler
 }
 
 // New returns a new initialized Router.
@@ -88,6 +98,11 @@ func (r *Router) RewriteHandle(handle skyrewrite.RewriteHandler) {
 	r.OnRequestFunc = handle
 }
 
+// Register a filter run before the request is rewritten, in registration order
+func (r *Router) FilterHandle(filter skyrewrite.FilterHandler) {
+	r.Filters = append(r.Filters, filter)
+}
+
 // GET is a shortcut for router.Handle("GET", path, handle)
 func (r *Router) GET(path string, handle *skyrewrite.SkyRewrite) {
 	r.Handle("GET", path, handle)
@@ -152,6 +167,28 @@ func (r *Router) Handle(method, path string, handle *skyrewrite.SkyRewrite) {
 		r.trees[method] = root
 	}
 	root.addRoute(path, handle)
+	r.routes.Store(r.trees)
+}
+
+// Swap atomically replaces the routes served by r with the routes registered
+// on fresh. Handle is not concurrency-safe, so a running router should never
+// be modified in place: build a new Router and Swap it in instead.
+// Requests already being served keep using the trees they started with.
+func (r *Router) Swap(fresh *Router) {
+	trees := fresh.trees
+	if trees == nil {
+		trees = make(map[string]*node)
+	}
+	r.trees = trees
+	r.routes.Store(trees)
+}
+
+// loadTrees returns the trees currently published for Handler.
+func (r *Router) loadTrees() map[string]*node {
+	if trees, ok := r.routes.Load().(map[string]*node); ok {
+		return trees
+	}
+	return nil
 }
 
 // ServeFiles serves files from the given file system root.
@@ -190,15 +227,15 @@ func (r *Router) recv(ctx *fasthttp.RequestCtx) {
 // values. Otherwise the third return value indicates whether a redirection to
 // the same path with an extra / without the trailing slash should be performed.
 func (r *Router) Lookup(method, path string, ctx *fasthttp.RequestCtx) (*skyrewrite.SkyRewrite, bool, int) {
-	if root := r.trees[method]; root != nil {
+	if root := r.loadTrees()[method]; root != nil {
 		return root.getValue(path, ctx)
 	}
 	return nil, false,0
 }
 
-func (r *Router) allowed(path, reqMethod string) (allow string) {
+func (r *Router) allowed(trees map[string]*node, path, reqMethod string) (allow string) {
 	if path == "*" || path == "/*" { // server-wide
-		for method := range r.trees {
+		for method := range trees {
 			if method == "OPTIONS" {
 				continue
 			}
@@ -211,13 +248,13 @@ func (r *Router) allowed(path, reqMethod string) (allow string) {
 			}
 		}
 	} else { // specific path
-		for method := range r.trees {
+		for method := range trees {
 			// Skip the requested method - we already tried this one
 			if method == reqMethod || method == "OPTIONS" {
 				continue
 			}
 
-			handle, _,_ := r.trees[method].getValue(path, nil)
+			handle, _,_ := trees[method].getValue(path, nil)
 			if handle != nil {
 				// add request method to list of allowed methods
 				if len(allow) == 0 {
@@ -235,9 +272,12 @@ func (r *Router) allowed(path, reqMethod string) (allow string) {
 }
 
 /**
- *根据重写规则，重写请求
+ *根据重写规则，重写请求;重写结果写入本次请求的RewriteResult,不修改共享的SkyRewrite
  */
 func (r *Router) RewriteRequest(ctx *fasthttp.RequestCtx, rewriteUri *skyrewrite.SkyRewrite,paramCounter int) {
+	result := &skyrewrite.RewriteResult{
+		Rewrite: rewriteUri,
+	}
 	destUri := rewriteUri.DestUri
 	//传统api转为restful api
 	if rewriteUri.IsMatchOriginQueryString {
@@ -245,23 +285,22 @@ func (r *Router) RewriteRequest(ctx *fasthttp.RequestCtx, rewriteUri *skyrewrite
 
 		for _, key := range rewriteUri.QueryParams {
 			val := values.Get(key)
-			idx := paramCounter+1
+			paramCounter++
 
-			sep := "$" + strconv.Itoa(idx)
+			sep := "$" + strconv.Itoa(paramCounter)
 			destUri = strings.Replace(destUri, sep, val, -1)
 		}
 	}
 	uriPath := string(ctx.URI().Path())
-	rewriteUri.RewriteUri = rewriteUri.Regexp.ReplaceAllString(uriPath, destUri)
+	result.Uri = rewriteUri.Regexp.ReplaceAllString(uriPath, destUri)
 
 	if rewriteUri.IsMatchDestQueryString {
-		querys := strings.Split(rewriteUri.RewriteUri, "?");
-		rewriteUri.RewriteUri = querys[0]
-		rewriteUri.RewriteQueryString = querys[1]
+		querys := strings.SplitN(result.Uri, "?", 2)
+		result.Uri = querys[0]
+		result.QueryString = querys[1]
 	}
 
-	//fmt.Fprintf(ctx, "%q\n", rewriteUri.Regexp.ReplaceAllString(uriPath, destUri))
-	r.OnRequestFunc(ctx, rewriteUri);
+	r.OnRequestFunc(ctx, result)
 }
 
 // Handler makes the router implement the fasthttp.ListenAndServe interface.
@@ -276,9 +315,15 @@ func (r *Router) Handler(ctx *fasthttp.RequestCtx) {
 	queryString := string(ctx.URI().QueryString())
 	log.Println("freeRouter Handler start:", path, queryString)
 	method := string(ctx.Method())
+	trees := r.loadTrees()
 
-	if root := r.trees[method]; root != nil {
+	if root := trees[method]; root != nil {
 		if requestHandler, tsr,counter := root.getValue(path, ctx); requestHandler != nil {
+			for _, filter := range r.Filters {
+				if !filter(ctx, requestHandler) {
+					return
+				}
+			}
 			r.RewriteRequest(ctx, requestHandler,counter)
 			return
 		} else if method != "CONNECT" && path != "/" {
@@ -324,7 +369,7 @@ func (r *Router) Handler(ctx *fasthttp.RequestCtx) {
 	if method == "OPTIONS" {
 		// Handle OPTIONS requests
 		if r.HandleOPTIONS {
-			if allow := r.allowed(path, method); len(allow) > 0 {
+			if allow := r.allowed(trees, path, method); len(allow) > 0 {
 				ctx.Response.Header.Set("Allow", allow)
 				return
 			}
@@ -332,7 +377,7 @@ func (r *Router) Handler(ctx *fasthttp.RequestCtx) {
 	} else {
 		// Handle 405
 		if r.HandleMethodNotAllowed {
-			if allow := r.allowed(path, method); len(allow) > 0 {
+			if allow := r.allowed(trees, path, method); len(allow) > 0 {
 				ctx.Response.Header.Set("Allow", allow)
 				if r.MethodNotAllowed != nil {
 					r.MethodNotAllowed(ctx)
@@ -0,0 +1,223 @@
+package skyupstream
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"skyway/managerapi/model"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+/**
+ * 负载均衡器,从候选节点中为请求选择一个节点,候选为空时返回nil
+ */
+type Balancer interface {
+	Pick(ctx *fasthttp.RequestCtx, candidates []*Target) *Target
+}
+
+/**
+ * 根据服务配置创建负载均衡器,未知策略按round-robin处理
+ */
+func NewBalancer(service *model.Service, targets []*Target) Balancer {
+	switch service.LbStrategy {
+	case model.LB_WEIGHTED_ROUND_ROBIN:
+		return &weightedRoundRobin{}
+	case model.LB_LEAST_CONN:
+		return &leastConn{}
+	case model.LB_RANDOM_TWO:
+		return &randomTwo{}
+	case model.LB_HASH:
+		return newConsistentHash(service.HashOn, service.HashKey, targets)
+	default:
+		return &roundRobin{}
+	}
+}
+
+/**
+ * 轮询
+ */
+type roundRobin struct {
+	next uint32
+}
+
+func (b *roundRobin) Pick(ctx *fasthttp.RequestCtx, candidates []*Target) *Target {
+	if len(candidates) == 0 {
+		return nil
+	}
+	n := atomic.AddUint32(&b.next, 1)
+	return candidates[(n-1)%uint32(len(candidates))]
+}
+
+/**
+ * 平滑加权轮询,与nginx一致: 每次所有节点加上自身权重,选出当前权重最大者并减去总权重
+ */
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+func (b *weightedRoundRobin) Pick(ctx *fasthttp.RequestCtx, candidates []*Target) *Target {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current == nil {
+		b.current = make(map[*Target]int)
+	}
+
+	var best *Target
+	total := 0
+	for _, target := range candidates {
+		b.current[target] += target.Weight
+		total += target.Weight
+		if best == nil || b.current[target] > b.current[best] {
+			best = target
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+/**
+ * 最少连接,按 活跃连接数/权重 比较
+ */
+type leastConn struct {
+	next uint32
+}
+
+func (b *leastConn) Pick(ctx *fasthttp.RequestCtx, candidates []*Target) *Target {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	//从轮询位置开始比较,负载相同时节点间轮流被选中
+	n := int(atomic.AddUint32(&b.next, 1))
+	var best *Target
+	for i := range candidates {
+		target := candidates[(n+i)%len(candidates)]
+		if best == nil || lessLoaded(target, best) {
+			best = target
+		}
+	}
+	return best
+}
+
+/**
+ * 随机选两个节点,取负载较低者
+ */
+type randomTwo struct{}
+
+func (b *randomTwo) Pick(ctx *fasthttp.RequestCtx, candidates []*Target) *Target {
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if lessLoaded(candidates[j], candidates[i]) {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+/**
+ * a的 活跃连接数/权重 是否小于b
+ */
+func lessLoaded(a, b *Target) bool {
+	return int64(a.ActiveConns())*int64(b.Weight) < int64(b.ActiveConns())*int64(a.Weight)
+}
+
+// 每单位权重的虚拟节点数
+const virtualNodesPerWeight = 100
+
+type ringNode struct {
+	hash   uint32
+	target *Target
+}
+
+/**
+ * 一致性哈希,按header,cookie或客户端IP取值;取不到值时退化为轮询
+ */
+type consistentHash struct {
+	hashOn   string
+	hashKey  string
+	ring     []ringNode
+	fallback roundRobin
+}
+
+func newConsistentHash(hashOn string, hashKey string, targets []*Target) *consistentHash {
+	b := &consistentHash{
+		hashOn:  hashOn,
+		hashKey: hashKey,
+	}
+	for _, target := range targets {
+		for i := 0; i < target.Weight*virtualNodesPerWeight; i++ {
+			b.ring = append(b.ring, ringNode{
+				hash:   hashString(target.Addr + "#" + strconv.Itoa(i)),
+				target: target,
+			})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool {
+		return b.ring[i].hash < b.ring[j].hash
+	})
+	return b
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+/**
+ * 读取请求中的哈希取值
+ */
+func (b *consistentHash) key(ctx *fasthttp.RequestCtx) string {
+	switch b.hashOn {
+	case model.HASH_ON_HEADER:
+		return string(ctx.Request.Header.Peek(b.hashKey))
+	case model.HASH_ON_COOKIE:
+		return string(ctx.Request.Header.Cookie(b.hashKey))
+	case model.HASH_ON_IP:
+		return ctx.RemoteIP().String()
+	}
+	return ""
+}
+
+func (b *consistentHash) Pick(ctx *fasthttp.RequestCtx, candidates []*Target) *Target {
+	if len(candidates) == 0 {
+		return nil
+	}
+	key := b.key(ctx)
+	if key == "" || len(b.ring) == 0 {
+		return b.fallback.Pick(ctx, candidates)
+	}
+
+	//顺时针找到第一个属于候选集合的节点,不可用节点的流量落到环上的下一个节点
+	hash := hashString(key)
+	start := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= hash
+	})
+	for i := 0; i < len(b.ring); i++ {
+		target := b.ring[(start+i)%len(b.ring)].target
+		for _, candidate := range candidates {
+			if candidate == target {
+				return target
+			}
+		}
+	}
+	return b.fallback.Pick(ctx, candidates)
+}
@@ -0,0 +1,80 @@
+package skyupstream
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"net"
+)
+
+var ErrReadTimeout = errors.New("timeout when reading upstream response")
+
+/**
+ * 转发错误分类
+ */
+type ErrorClass int
+
+const (
+	ErrorNone ErrorClass = iota
+	//拒绝连接,DNS解析失败等,请求未发出
+	ErrorConnect
+	//建立连接超时,请求未发出
+	ErrorConnectTimeout
+	//读写超时,请求可能已被后端处理
+	ErrorTimeout
+	//其他错误,如连接被后端关闭
+	ErrorOther
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorNone:
+		return "none"
+	case ErrorConnect:
+		return "connect"
+	case ErrorConnectTimeout:
+		return "connect-timeout"
+	case ErrorTimeout:
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+/**
+ * 请求是否未发到后端
+ */
+func (c ErrorClass) NotSent() bool {
+	return c == ErrorConnect || c == ErrorConnectTimeout
+}
+
+/**
+ * 判断转发错误类型
+ */
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ErrorNone
+	}
+	if errors.Is(err, fasthttp.ErrDialTimeout) {
+		return ErrorConnectTimeout
+	}
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, ErrReadTimeout) {
+		return ErrorTimeout
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorConnect
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		if opErr.Timeout() {
+			return ErrorConnectTimeout
+		}
+		return ErrorConnect
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorTimeout
+	}
+	return ErrorOther
+}
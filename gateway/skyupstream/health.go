@@ -0,0 +1,204 @@
+package skyupstream
+
+import (
+	"github.com/valyala/fasthttp"
+	"log"
+	"skyway/managerapi/model"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCheckInterval      = 5000
+	defaultCheckTimeout       = 1000
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+	defaultEjectTime          = 30000
+)
+
+/**
+ * 补全主动健康检查的默认值,未配置Path时返回nil
+ */
+func healthCheckSettings(check *model.HealthCheck) *model.HealthCheck {
+	if check == nil || check.Path == "" {
+		return nil
+	}
+	settings := *check
+	if settings.Interval <= 0 {
+		settings.Interval = defaultCheckInterval
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = defaultCheckTimeout
+	}
+	if settings.HealthyThreshold <= 0 {
+		settings.HealthyThreshold = defaultHealthyThreshold
+	}
+	if settings.UnhealthyThreshold <= 0 {
+		settings.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	return &settings
+}
+
+/**
+ * 节点当前是否参与负载均衡: 主动检查健康且未被被动摘除
+ */
+func (t *Target) Available() bool {
+	return t.Healthy() && !t.Ejected()
+}
+
+/**
+ * 主动检查结果是否健康
+ */
+func (t *Target) Healthy() bool {
+	return atomic.LoadInt32(&t.unhealthy) == 0
+}
+
+/**
+ * 是否处于被动摘除期
+ */
+func (t *Target) Ejected() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&t.ejectedUntil)
+}
+
+/**
+ * 被动检查: 记录一次转发结果,连续5xx或连接错误达到阈值后摘除节点
+ */
+func (t *Target) Report(statusCode int, err error) {
+	if t.maxFailures <= 0 {
+		return
+	}
+	if err == nil && statusCode < fasthttp.StatusInternalServerError {
+		atomic.StoreInt32(&t.failures, 0)
+		return
+	}
+	if atomic.AddInt32(&t.failures, 1) >= int32(t.maxFailures) {
+		atomic.StoreInt32(&t.failures, 0)
+		atomic.StoreInt64(&t.ejectedUntil, time.Now().Add(t.ejectTime).UnixNano())
+		log.Printf("target %s ejected for %s, status=%d, err=%v", t.Addr, t.ejectTime, statusCode, err)
+	}
+}
+
+/**
+ * 主动检查: 请求一次检查路径
+ */
+func (t *Target) probe(check *model.HealthCheck) bool {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(check.Path)
+	req.Header.SetHost(t.Addr)
+	err := t.client.DoTimeout(req, resp, time.Duration(check.Timeout)*time.Millisecond)
+	statusCode := resp.StatusCode()
+	return err == nil && statusCode >= fasthttp.StatusOK && statusCode < fasthttp.StatusBadRequest
+}
+
+/**
+ * 根据检查结果更新健康状态,达到阈值时切换
+ */
+func (t *Target) updateHealth(check *model.HealthCheck, ok bool) {
+	if ok {
+		t.checkFailures = 0
+		t.checkSuccesses++
+		if !t.Healthy() && t.checkSuccesses >= check.HealthyThreshold {
+			atomic.StoreInt32(&t.unhealthy, 0)
+			log.Printf("target %s is healthy", t.Addr)
+		}
+		return
+	}
+
+	t.checkSuccesses = 0
+	t.checkFailures++
+	if t.Healthy() && t.checkFailures >= check.UnhealthyThreshold {
+		atomic.StoreInt32(&t.unhealthy, 1)
+		log.Printf("target %s is unhealthy", t.Addr)
+	}
+}
+
+/**
+ * 启动主动健康检查,每个服务一个goroutine,Stop后退出
+ */
+func (u *Upstream) startHealthCheck() {
+	if u.healthCheck == nil || len(u.Targets) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(u.healthCheck.Interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			for _, target := range u.Targets {
+				target.updateHealth(u.healthCheck, target.probe(u.healthCheck))
+			}
+			select {
+			case <-u.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+/**
+ * 停止主动健康检查
+ */
+func (u *Upstream) Stop() {
+	close(u.stop)
+}
+
+/**
+ * 从旧的服务继承节点健康状态,避免重新加载后不健康的节点立即恢复;
+ * 主动检查的结果只在新服务仍开启主动检查时继承,否则没有检查能恢复节点,按健康处理
+ */
+func (u *Upstream) inherit(previous *Upstream) {
+	if previous == nil {
+		return
+	}
+	for _, target := range u.Targets {
+		for _, old := range previous.Targets {
+			if old.Addr == target.Addr {
+				if u.healthCheck != nil {
+					atomic.StoreInt32(&target.unhealthy, atomic.LoadInt32(&old.unhealthy))
+				}
+				atomic.StoreInt64(&target.ejectedUntil, atomic.LoadInt64(&old.ejectedUntil))
+				break
+			}
+		}
+	}
+}
+
+/**
+ * 节点状态,用于管理接口展示
+ */
+type TargetStatus struct {
+	Addr        string
+	Weight      int
+	Healthy     bool
+	Ejected     bool
+	ActiveConns int32
+}
+
+type UpstreamStatus struct {
+	ServiceId   int
+	ServiceName string
+	Targets     []*TargetStatus
+}
+
+func (u *Upstream) Status() *UpstreamStatus {
+	status := &UpstreamStatus{
+		ServiceId:   u.ServiceId,
+		ServiceName: u.ServiceName,
+		Targets:     make([]*TargetStatus, 0, len(u.Targets)),
+	}
+	for _, target := range u.Targets {
+		status.Targets = append(status.Targets, &TargetStatus{
+			Addr:        target.Addr,
+			Weight:      target.Weight,
+			Healthy:     target.Healthy(),
+			Ejected:     target.Ejected(),
+			ActiveConns: target.ActiveConns(),
+		})
+	}
+	return status
+}
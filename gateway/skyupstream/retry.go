@@ -0,0 +1,174 @@
+package skyupstream
+
+import (
+	"math/rand"
+	"skyway/managerapi/model"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackoffBase        = 25
+	defaultBackoffMax         = 1000
+	defaultBudgetPercent      = 20
+	defaultBudgetMinPerSecond = 3
+
+	//重试预算统计窗口,单位秒
+	budgetWindow = 10
+)
+
+/**
+ * 幂等的请求方法
+ */
+func IsIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE", "TRACE":
+		return true
+	}
+	return false
+}
+
+/**
+ * 按重试策略判断一次失败的请求是否应该重试,不检查次数和预算
+ */
+func ShouldRetry(policy *model.RetryPolicy, method string, statusCode int, err error) bool {
+	if policy == nil {
+		return false
+	}
+
+	class := Classify(err)
+	//请求未发出时重试对非幂等请求也是安全的
+	if !class.NotSent() && !policy.AllowNonIdempotent && !IsIdempotent(method) {
+		return false
+	}
+
+	switch class {
+	case ErrorNone:
+		for _, code := range policy.RetryOn {
+			if code == statusCode {
+				return true
+			}
+		}
+		return false
+	case ErrorConnect, ErrorConnectTimeout:
+		return policy.RetryOnConnectError
+	case ErrorTimeout:
+		return policy.RetryOnTimeout
+	}
+	return false
+}
+
+/**
+ * 第attempt次重试前的等待时长,指数退避加全抖动
+ */
+func Backoff(policy *model.RetryPolicy, attempt int) time.Duration {
+	base := policy.BackoffBase
+	if base <= 0 {
+		base = defaultBackoffBase
+	}
+	max := policy.BackoffMax
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+
+	wait := base
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return time.Duration(rand.Int63n(int64(wait)*int64(time.Millisecond) + 1))
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+/**
+ * 重试预算: 窗口内重试数不超过请求数的BudgetPercent,且每秒保底BudgetMinPerSecond次,
+ * 防止后端故障时重试放大流量
+ */
+type RetryBudget struct {
+	mu      sync.Mutex
+	buckets [budgetWindow]budgetBucket
+}
+
+func (b *RetryBudget) bucket(now int64) *budgetBucket {
+	current := &b.buckets[now%budgetWindow]
+	if current.second != now {
+		*current = budgetBucket{second: now}
+	}
+	return current
+}
+
+/**
+ * 记录一次请求
+ */
+func (b *RetryBudget) Request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket(time.Now().Unix()).requests++
+}
+
+/**
+ * 申请一次重试,预算不足时返回false
+ */
+func (b *RetryBudget) Withdraw(policy *model.RetryPolicy) bool {
+	percent := policy.BudgetPercent
+	if percent <= 0 {
+		percent = defaultBudgetPercent
+	}
+	minPerSecond := policy.BudgetMinPerSecond
+	if minPerSecond <= 0 {
+		minPerSecond = defaultBudgetMinPerSecond
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
+	requests, retries := 0, 0
+	for _, item := range b.buckets {
+		if item.second > now-budgetWindow {
+			requests += item.requests
+			retries += item.retries
+		}
+	}
+
+	allowed := requests * percent / 100
+	if allowed < minPerSecond*budgetWindow {
+		allowed = minPerSecond * budgetWindow
+	}
+	if retries >= allowed {
+		return false
+	}
+	b.bucket(now).retries++
+	return true
+}
+
+/**
+ * 重试预算集合,按API索引
+ */
+type RetryBudgets struct {
+	mu      sync.Mutex
+	budgets map[int]*RetryBudget
+}
+
+func NewRetryBudgets() *RetryBudgets {
+	return &RetryBudgets{
+		budgets: make(map[int]*RetryBudget),
+	}
+}
+
+func (r *RetryBudgets) Get(apiId int) *RetryBudget {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	budget := r.budgets[apiId]
+	if budget == nil {
+		budget = &RetryBudget{}
+		r.budgets[apiId] = budget
+	}
+	return budget
+}
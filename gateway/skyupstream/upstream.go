@@ -0,0 +1,253 @@
+package skyupstream
+
+import (
+	"github.com/valyala/fasthttp"
+	"net"
+	"skyway/managerapi/model"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * 后端服务节点,每个节点独立维护连接池
+ */
+type Target struct {
+	Addr   string
+	Weight int
+	client *fasthttp.HostClient
+	active int32
+
+	//服务的默认超时及对应连接池client;API覆盖了超时设置时,按设置另建连接池,存于clients
+	timeouts model.Timeouts
+	clients  sync.Map
+
+	//主动检查状态,计数器只由检查goroutine访问
+	unhealthy      int32
+	checkSuccesses int
+	checkFailures  int
+
+	//被动检查状态
+	maxFailures  int
+	ejectTime    time.Duration
+	failures     int32
+	ejectedUntil int64
+}
+
+func newTarget(target *model.Target, service *model.Service) *Target {
+	weight := target.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	t := &Target{
+		Addr:     target.Addr,
+		Weight:   weight,
+		client:   newHostClient(target.Addr, service.Timeouts),
+		timeouts: clientTimeouts(service.Timeouts),
+	}
+	if outlier := service.OutlierDetection; outlier != nil && outlier.MaxFailures > 0 {
+		t.maxFailures = outlier.MaxFailures
+		t.ejectTime = time.Duration(outlier.EjectTime) * time.Millisecond
+		if t.ejectTime <= 0 {
+			t.ejectTime = defaultEjectTime * time.Millisecond
+		}
+	}
+	return t
+}
+
+/**
+ * 创建连接池,RequestTimeout在每次请求时单独控制
+ */
+func newHostClient(addr string, timeouts model.Timeouts) *fasthttp.HostClient {
+	client := &fasthttp.HostClient{
+		Addr:                addr,
+		ReadTimeout:         time.Duration(timeouts.ReadTimeout) * time.Millisecond,
+		WriteTimeout:        time.Duration(timeouts.WriteTimeout) * time.Millisecond,
+		MaxIdleConnDuration: time.Duration(timeouts.IdleTimeout) * time.Millisecond,
+		//重试由API的重试策略控制,fasthttp不再自行重试
+		MaxIdemponentCallAttempts: 1,
+	}
+	if timeouts.ConnectTimeout > 0 {
+		connectTimeout := time.Duration(timeouts.ConnectTimeout) * time.Millisecond
+		client.Dial = func(addr string) (net.Conn, error) {
+			return fasthttp.DialTimeout(addr, connectTimeout)
+		}
+	}
+	return client
+}
+
+/**
+ * 与连接池相关的超时设置,去掉按请求控制的RequestTimeout,作为连接池的索引
+ */
+func clientTimeouts(timeouts model.Timeouts) model.Timeouts {
+	timeouts.RequestTimeout = 0
+	return timeouts
+}
+
+/**
+ * 获取指定超时设置对应的连接池
+ */
+func (t *Target) clientFor(timeouts model.Timeouts) *fasthttp.HostClient {
+	key := clientTimeouts(timeouts)
+	if key == t.timeouts {
+		return t.client
+	}
+	if client, ok := t.clients.Load(key); ok {
+		return client.(*fasthttp.HostClient)
+	}
+	client, _ := t.clients.LoadOrStore(key, newHostClient(t.Addr, key))
+	return client.(*fasthttp.HostClient)
+}
+
+/**
+ * 按指定超时设置转发请求到该节点
+ */
+func (t *Target) Do(req *fasthttp.Request, resp *fasthttp.Response, timeouts model.Timeouts) error {
+	atomic.AddInt32(&t.active, 1)
+	defer atomic.AddInt32(&t.active, -1)
+
+	client := t.clientFor(timeouts)
+	start := time.Now()
+	var err error
+	if timeouts.RequestTimeout > 0 {
+		err = client.DoTimeout(req, resp, time.Duration(timeouts.RequestTimeout)*time.Millisecond)
+	} else {
+		err = client.Do(req, resp)
+	}
+
+	//fasthttp把读取响应首字节超时当作连接关闭返回,按耗时还原为读超时
+	readTimeout := time.Duration(timeouts.ReadTimeout) * time.Millisecond
+	if err == fasthttp.ErrConnectionClosed && readTimeout > 0 && time.Since(start) >= readTimeout {
+		err = ErrReadTimeout
+	}
+	return err
+}
+
+/**
+ * 正在处理的请求数
+ */
+func (t *Target) ActiveConns() int32 {
+	return atomic.LoadInt32(&t.active)
+}
+
+/**
+ * 一个服务及其全部后端节点
+ */
+type Upstream struct {
+	ServiceId   int
+	ServiceName string
+	Targets     []*Target
+	timeouts    model.Timeouts
+	balancer    Balancer
+	healthCheck *model.HealthCheck
+	stop        chan struct{}
+}
+
+func NewUpstream(service *model.Service) *Upstream {
+	upstream := &Upstream{
+		ServiceId:   service.ServiceId,
+		ServiceName: service.ServiceName,
+		timeouts:    service.Timeouts,
+		healthCheck: healthCheckSettings(service.HealthCheck),
+		stop:        make(chan struct{}),
+	}
+	for _, target := range service.Targets {
+		if target == nil || target.Addr == "" {
+			continue
+		}
+		upstream.Targets = append(upstream.Targets, newTarget(target, service))
+	}
+	upstream.balancer = NewBalancer(service, upstream.Targets)
+	return upstream
+}
+
+/**
+ * API的转发超时: 服务的默认设置被API上非0的项覆盖
+ */
+func (u *Upstream) Timeouts(override *model.Timeouts) model.Timeouts {
+	return u.timeouts.Merge(override)
+}
+
+/**
+ * 当前可用且被accept接受的节点,accept为空时返回全部可用节点
+ */
+func (u *Upstream) Available(accept func(target *Target) bool) []*Target {
+	available := make([]*Target, 0, len(u.Targets))
+	for _, target := range u.Targets {
+		if target.Available() && (accept == nil || accept(target)) {
+			available = append(available, target)
+		}
+	}
+	return available
+}
+
+/**
+ * 按服务的负载均衡策略从可用节点中选择一个,没有可用节点时返回nil
+ */
+func (u *Upstream) Pick(ctx *fasthttp.RequestCtx, accept func(target *Target) bool) *Target {
+	return u.balancer.Pick(ctx, u.Available(accept))
+}
+
+/**
+ * 服务注册表,按ServiceId索引;创建后只读,变更时整体替换
+ */
+type Registry struct {
+	upstreams map[int]*Upstream
+}
+
+/**
+ * 创建服务注册表并启动健康检查,previous不为空时继承其节点健康状态
+ */
+func NewRegistry(services []*model.Service, previous *Registry) *Registry {
+	registry := &Registry{
+		upstreams: make(map[int]*Upstream),
+	}
+	for _, service := range services {
+		upstream := NewUpstream(service)
+		upstream.inherit(previous.Get(service.ServiceId))
+		upstream.startHealthCheck()
+		registry.upstreams[service.ServiceId] = upstream
+	}
+	return registry
+}
+
+/**
+ * 停止全部服务的健康检查,注册表被替换后调用
+ */
+func (r *Registry) Stop() {
+	if r == nil {
+		return
+	}
+	for _, upstream := range r.upstreams {
+		upstream.Stop()
+	}
+}
+
+/**
+ * 全部服务的节点状态,按ServiceId排序
+ */
+func (r *Registry) Status() []*UpstreamStatus {
+	status := make([]*UpstreamStatus, 0)
+	if r == nil {
+		return status
+	}
+	for _, upstream := range r.upstreams {
+		status = append(status, upstream.Status())
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].ServiceId < status[j].ServiceId
+	})
+	return status
+}
+
+/**
+ * 获取指定服务,不存在时返回nil
+ */
+func (r *Registry) Get(serviceId int) *Upstream {
+	if r == nil {
+		return nil
+	}
+	return r.upstreams[serviceId]
+}
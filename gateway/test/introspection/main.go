@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"skyway/gateway/skyoauth"
+	"skyway/managerapi/model"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	stubClientId     = "skyway"
+	stubClientSecret = "skyway-secret"
+)
+
+/**
+ * 模拟授权服务器的自省接口(RFC 7662),记录调用次数
+ */
+type stubServer struct {
+	mu     sync.Mutex
+	tokens map[string]*skyoauth.Result
+	calls  int64
+	//自省接口的响应延迟,纳秒
+	delay int64
+}
+
+func newStubServer() *stubServer {
+	exp := time.Now().Add(time.Hour).Unix()
+	return &stubServer{
+		tokens: map[string]*skyoauth.Result{
+			"token-rw":      {Active: true, Sub: "alice", Scope: "orders:read orders:write", Exp: exp},
+			"token-ro":      {Active: true, Sub: "bob", Scope: "orders:read", Exp: exp},
+			"token-revoked": {Active: false},
+		},
+	}
+}
+
+/**
+ * 添加或替换Token,ttl秒后过期
+ */
+func (stub *stubServer) setToken(token string, sub string, scope string, ttl int) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.tokens[token] = &skyoauth.Result{Active: true, Sub: sub, Scope: scope, Exp: time.Now().Add(time.Duration(ttl) * time.Second).Unix()}
+}
+
+/**
+ * 吊销Token,已缓存的结果在过期前仍然有效
+ */
+func (stub *stubServer) revoke(token string) {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	stub.tokens[token] = &skyoauth.Result{Active: false}
+}
+
+/**
+ * POST /introspect 校验客户端凭证后返回Token状态,未知Token返回active=false;
+ * POST /tokens?token=&sub=&scope=&ttl= 添加Token,DELETE /tokens?token= 吊销Token
+ */
+func (stub *stubServer) handler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	switch string(ctx.Path()) {
+	case "/introspect":
+		atomic.AddInt64(&stub.calls, 1)
+		time.Sleep(time.Duration(atomic.LoadInt64(&stub.delay)))
+		credentials := base64.StdEncoding.EncodeToString([]byte(stubClientId + ":" + stubClientSecret))
+		if !bytes.Equal(ctx.Request.Header.Peek("Authorization"), []byte("Basic "+credentials)) {
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			return
+		}
+		token := string(ctx.PostArgs().Peek("token"))
+		stub.mu.Lock()
+		result := stub.tokens[token]
+		stub.mu.Unlock()
+		if result == nil {
+			result = &skyoauth.Result{Active: false}
+		}
+		data, _ := json.Marshal(result)
+		ctx.SetContentType("application/json")
+		ctx.SetBody(data)
+	case "/tokens":
+		token := string(args.Peek("token"))
+		if string(ctx.Method()) == "DELETE" {
+			stub.revoke(token)
+			return
+		}
+		ttl, err := strconv.Atoi(string(args.Peek("ttl")))
+		if err != nil {
+			ttl = 3600
+		}
+		stub.setToken(token, string(args.Peek("sub")), string(args.Peek("scope")), ttl)
+	default:
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+	}
+}
+
+func check(name string, ok bool) bool {
+	if ok {
+		fmt.Printf("%s: ok\n", name)
+	} else {
+		fmt.Printf("%s: FAILED\n", name)
+	}
+	return ok
+}
+
+/**
+ * 用本地模拟服务器检查自省结果,scope判断和缓存
+ * 运行: go run ./gateway/test/introspection
+ */
+func testIntrospection() bool {
+	stub := newStubServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ln.Close()
+	go fasthttp.Serve(ln, stub.handler)
+
+	policy := &model.OAuth2Policy{
+		IntrospectionUrl: "http://" + ln.Addr().String() + "/introspect",
+		ClientId:         stubClientId,
+		ClientSecret:     stubClientSecret,
+	}
+	introspector := skyoauth.NewIntrospector()
+	passed := true
+
+	result, err := introspector.Introspect(policy, "token-rw")
+	passed = check("active token", err == nil && result.Active && result.Sub == "alice") && passed
+	_, ok := result.HasScopes([]string{"orders:write"})
+	passed = check("scope present", ok) && passed
+	result, err = introspector.Introspect(policy, "token-ro")
+	missing, ok := result.HasScopes([]string{"orders:read", "orders:write"})
+	passed = check("scope missing", err == nil && !ok && missing == "orders:write") && passed
+	result, err = introspector.Introspect(policy, "token-revoked")
+	passed = check("revoked token", err == nil && !result.Active) && passed
+	result, err = introspector.Introspect(policy, "token-unknown")
+	passed = check("unknown token", err == nil && !result.Active) && passed
+
+	//缓存期间不再调用授权服务器,吊销在缓存过期前不生效
+	calls := atomic.LoadInt64(&stub.calls)
+	stub.revoke("token-rw")
+	result, err = introspector.Introspect(policy, "token-rw")
+	passed = check("cached result", err == nil && result.Active && atomic.LoadInt64(&stub.calls) == calls) && passed
+
+	//结果只缓存到Token过期
+	stub.setToken("token-short", "carol", "orders:read", 1)
+	result, err = introspector.Introspect(policy, "token-short")
+	passed = check("short token active", err == nil && result.Active) && passed
+	time.Sleep(1100 * time.Millisecond)
+	result, err = introspector.Introspect(policy, "token-short")
+	passed = check("short token expired", err == nil && !result.Active) && passed
+
+	//同一Token的并发查询只调用一次授权服务器
+	stub.setToken("token-burst", "dave", "orders:read", 3600)
+	atomic.StoreInt64(&stub.delay, int64(200*time.Millisecond))
+	calls = atomic.LoadInt64(&stub.calls)
+	var wg sync.WaitGroup
+	var active int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result, err := introspector.Introspect(policy, "token-burst"); err == nil && result.Active {
+				atomic.AddInt64(&active, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	atomic.StoreInt64(&stub.delay, 0)
+	passed = check("concurrent lookups coalesced", active == 20 && atomic.LoadInt64(&stub.calls) == calls+1) && passed
+
+	wrong := *policy
+	wrong.ClientSecret = "wrong"
+	_, err = introspector.Introspect(&wrong, "token-unknown-2")
+	passed = check("wrong client credentials", err != nil) && passed
+	return passed
+}
+
+func main() {
+	serve := flag.String("serve", "", "run only the stub introspection server on this address, e.g. 127.0.0.1:9700")
+	flag.Parse()
+
+	if *serve != "" {
+		log.Printf("stub introspection server at http://%s/introspect, client %s:%s", *serve, stubClientId, stubClientSecret)
+		log.Fatal(fasthttp.ListenAndServe(*serve, newStubServer().handler))
+	}
+	if !testIntrospection() {
+		os.Exit(1)
+	}
+}
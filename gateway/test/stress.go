@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"skyway/gateway/skyrewrite"
+	"skyway/gateway/skyrouter"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+func newStressRouter() *skyrouter.Router {
+	router := skyrouter.New()
+
+	a := skyrewrite.New()
+	a.ApiId = 1000
+	a.DestUri = "/test.php?hello=$1&test=$2"
+	router.GET("/hello/{name}/test/{foo}", a)
+
+	c := skyrewrite.New()
+	c.ApiId = 1003
+	c.DestUri = "/user/$1/age/$2/addr/$3"
+	router.GET("/user/{id}/{age}?addr={addr}", c)
+	return router
+}
+
+/**
+ * 并发压测路由重写,检查同一路由上的并发请求互不覆盖重写结果
+ * 运行: go run -race ./gateway/test
+ */
+func testConcurrentRewrite() bool {
+	router := newStressRouter()
+
+	var failed int64
+	var mu sync.Mutex
+	router.RewriteHandle(func(ctx *fasthttp.RequestCtx, result *skyrewrite.RewriteResult) {
+		expect := string(ctx.Request.Header.Peek("X-Expect"))
+		got := result.Uri
+		if len(result.QueryString) > 0 {
+			got += "?" + result.QueryString
+		}
+		if got != expect {
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			fmt.Printf("api %d: expect %s, got %s\n", result.Rewrite.ApiId, expect, got)
+		}
+	})
+
+	const workers = 32
+	const requests = 500
+	var wg sync.WaitGroup
+
+	//压测期间不断重建路由并替换,模拟etcd热更新
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				router.Swap(newStressRouter())
+			}
+		}
+	}()
+
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < requests; i++ {
+				var req fasthttp.Request
+				if i%2 == 0 {
+					req.SetRequestURI(fmt.Sprintf("/hello/n%d/test/f%d", w, i))
+					req.Header.Set("X-Expect", fmt.Sprintf("/test.php?hello=n%d&test=f%d", w, i))
+				} else {
+					req.SetRequestURI(fmt.Sprintf("/user/%d/%d?addr=a%d", w, i, w))
+					req.Header.Set("X-Expect", fmt.Sprintf("/user/%d/age/%d/addr/a%d", w, i, w))
+				}
+				ctx := &fasthttp.RequestCtx{}
+				ctx.Init(&req, remoteAddr, nil)
+				router.Handler(ctx)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+
+	fmt.Printf("testConcurrentRewrite: %d requests, %d failed\n", workers*requests, failed)
+	return failed == 0
+}
+
+func main() {
+	log.SetOutput(ioutil.Discard)
+	if !testConcurrentRewrite() {
+		os.Exit(1)
+	}
+}
@@ -1,2 +1,111 @@
 package ServiceApi
 
+import (
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+/**
+ * 错误码
+ */
+const (
+	CODE_OK                    = "ok"
+	CODE_API_NOT_FOUND         = "api_not_found"
+	CODE_METHOD_NOT_ALLOWED    = "method_not_allowed"
+	CODE_NO_UPSTREAM_SERVICE   = "no_upstream_service"
+	CODE_NO_HEALTHY_UPSTREAM   = "no_healthy_upstream"
+	CODE_UPSTREAM_CONNECT      = "upstream_connect_error"
+	CODE_UPSTREAM_TIMEOUT      = "upstream_timeout"
+	CODE_UPSTREAM_ERROR        = "upstream_error"
+	CODE_CIRCUIT_OPEN          = "circuit_open"
+	CODE_INTERNAL_ERROR        = "internal_error"
+	CODE_BAD_REQUEST           = "bad_request"
+	CODE_NOT_FOUND             = "not_found"
+	CODE_CONFLICT              = "conflict"
+	CODE_VALIDATION_FAILED     = "validation_failed"
+	CODE_PRECONDITION_FAILED   = "precondition_failed"
+	CODE_PRECONDITION_REQUIRED = "precondition_required"
+	CODE_UNAUTHORIZED          = "unauthorized"
+	CODE_FORBIDDEN             = "forbidden"
+	CODE_AUTH_UNAVAILABLE      = "auth_unavailable"
+	CODE_RATE_LIMITED          = "rate_limited"
+)
+
+/**
+ * 按HTTP状态码取默认错误码
+ */
+func StatusCode(statusCode int) string {
+	switch statusCode {
+	case fasthttp.StatusBadRequest:
+		return CODE_BAD_REQUEST
+	case fasthttp.StatusUnauthorized:
+		return CODE_UNAUTHORIZED
+	case fasthttp.StatusForbidden:
+		return CODE_FORBIDDEN
+	case fasthttp.StatusNotFound:
+		return CODE_NOT_FOUND
+	case fasthttp.StatusMethodNotAllowed:
+		return CODE_METHOD_NOT_ALLOWED
+	case fasthttp.StatusConflict:
+		return CODE_CONFLICT
+	case fasthttp.StatusPreconditionFailed:
+		return CODE_PRECONDITION_FAILED
+	case fasthttp.StatusPreconditionRequired:
+		return CODE_PRECONDITION_REQUIRED
+	}
+	if statusCode < fasthttp.StatusBadRequest {
+		return CODE_OK
+	}
+	return CODE_INTERNAL_ERROR
+}
+
+/**
+ * 统一的JSON响应结构,网关错误响应和管理接口共用
+ */
+type DataResponse struct {
+	/**
+	 * 错误码,成功为ok
+	 */
+	Code string
+	/**
+	 * 错误信息
+	 */
+	Message string
+	/**
+	 * 请求ID,用于日志排查
+	 */
+	RequestId string `json:",omitempty"`
+	/**
+	 * 命中的API ID
+	 */
+	ApiId int `json:",omitempty"`
+	/**
+	 * 返回数据
+	 */
+	Data interface{} `json:",omitempty"`
+}
+
+func NewDataResponse(code string, message string) *DataResponse {
+	return &DataResponse{
+		Code:    code,
+		Message: message,
+	}
+}
+
+/**
+ * 以JSON输出响应,覆盖ctx中已有的响应内容
+ */
+func (response *DataResponse) Write(ctx *fasthttp.RequestCtx, statusCode int) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.Response.Reset()
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.SetBody(data)
+}
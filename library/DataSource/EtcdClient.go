@@ -3,6 +3,7 @@ package DataSource
 import (
 	"context"
 	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"sync"
 	"time"
 )
@@ -112,6 +113,18 @@ func (etcd *EtcdClient) GetAll(prefix string) (map[string]string, error) {
 	return HGet(etcd.client.Get(context.Background(), prefix, withPrefix))
 }
 
+/**
+ * Get By prefix,同时返回读取时etcd的版本号,用于之后判断前缀下的key是否被修改
+ */
+func (etcd *EtcdClient) GetAllWithRevision(prefix string) (map[string]string, int64, error) {
+	resp, err := etcd.client.Get(context.Background(), prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	result, _ := HGet(resp, nil)
+	return result, resp.Header.Revision, nil
+}
+
 /**
  * 获取最大键,用于获取最大ID,比如Key_001 ... Key_102 最大为Key_102
  */
@@ -158,6 +171,20 @@ func (etcd *EtcdClient) GetRange(startKey string, endKey string) (map[string]str
 	return HGet(etcd.client.Get(context.Background(), startKey, withRange))
 }
 
+/**
+ * Get By Range,[startKey,endKey)按key倒序,最多limit条;返回有序的键值对
+ */
+func (etcd *EtcdClient) GetRangeDescend(startKey string, endKey string, limit int) ([]*mvccpb.KeyValue, error) {
+	withRange := clientv3.WithRange(endKey)
+	withSort := clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend)
+	withLimit := clientv3.WithLimit(int64(limit))
+	resp, err := etcd.client.Get(context.Background(), startKey, withRange, withSort, withLimit)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Kvs, nil
+}
+
 /**
  * Get By Range,Contains StartKey[startKey,N-1]
  */
@@ -178,6 +205,18 @@ func (etcd *EtcdClient) Delete(key string) (int64, error) {
 	return ret.Deleted, nil
 }
 
+/**
+ * Delete By Range,Not Contains endKey,[startKey,endKey)
+ */
+func (etcd *EtcdClient) DeleteRange(startKey string, endKey string) (int64, error) {
+	withRange := clientv3.WithRange(endKey)
+	ret, err := etcd.client.Delete(context.Background(), startKey, withRange)
+	if err != nil {
+		return 0, err
+	}
+	return ret.Deleted, nil
+}
+
 /**
  * Delete All By Prefix
  */
@@ -189,3 +228,124 @@ func (etcd *EtcdClient) DeleteAll(prefix string) (int64, error) {
 	}
 	return ret.Deleted, nil
 }
+
+/**
+ * Watch By prefix,返回变更事件通道,ctx取消后通道关闭
+ */
+func (etcd *EtcdClient) WatchAll(ctx context.Context, prefix string) clientv3.WatchChan {
+	withPrefix := clientv3.WithPrefix()
+	return etcd.client.Watch(ctx, prefix, withPrefix)
+}
+
+/**
+ * Get Single Key,同时返回修改版本号,key不存在时版本号为0
+ */
+func (etcd *EtcdClient) GetWithRevision(key string) (string, int64, error) {
+	resp, err := etcd.client.Get(context.Background(), key)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, v := range resp.Kvs {
+		return string(v.Value), v.ModRevision, nil
+	}
+	return "", 0, nil
+}
+
+/**
+ * Compare And Swap,仅当key的修改版本号等于revision时写入,revision为0表示key不存在时才写入;
+ * 返回写入后的版本号,比较失败时返回0
+ */
+func (etcd *EtcdClient) CompareAndSwap(key string, revision int64, value string) (int64, error) {
+	return etcd.CompareAndCommit(key, revision, clientv3.OpPut(key, value))
+}
+
+/**
+ * Compare And Delete,仅当key的修改版本号等于revision时删除,返回是否删除
+ */
+func (etcd *EtcdClient) CompareAndDelete(key string, revision int64) (bool, error) {
+	committed, err := etcd.CompareAndCommit(key, revision, clientv3.OpDelete(key))
+	return committed > 0, err
+}
+
+/**
+ * 仅当key的修改版本号等于revision时在同一事务中执行全部操作;
+ * 返回事务提交后的版本号,比较失败时返回0
+ */
+func (etcd *EtcdClient) CompareAndCommit(key string, revision int64, ops ...clientv3.Op) (int64, error) {
+	return etcd.CompareAllAndCommit(map[string]int64{key: revision}, ops...)
+}
+
+/**
+ * 同CompareAndCommit,仅当revisions中每个key的修改版本号都一致时提交
+ */
+func (etcd *EtcdClient) CompareAllAndCommit(revisions map[string]int64, ops ...clientv3.Op) (int64, error) {
+	cmps := make([]clientv3.Cmp, 0, len(revisions))
+	for key, revision := range revisions {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", revision))
+	}
+	resp, err := etcd.client.Txn(context.Background()).
+		If(cmps...).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, nil
+	}
+	return resp.Header.Revision, nil
+}
+
+/**
+ * 同CompareAndCommit,并且prefixes下的key在版本号since之后都没有被新增或修改时才提交
+ */
+func (etcd *EtcdClient) CompareUnchangedAndCommit(key string, revision int64, prefixes []string, since int64, ops ...clientv3.Op) (int64, error) {
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(key), "=", revision)}
+	for _, prefix := range prefixes {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(prefix), "<", since+1).WithPrefix())
+	}
+	resp, err := etcd.client.Txn(context.Background()).
+		If(cmps...).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, nil
+	}
+	return resp.Header.Revision, nil
+}
+
+/**
+ * 申请租约,ttl秒后过期;绑定租约的key随租约一起删除
+ */
+func (etcd *EtcdClient) Grant(ttl int64) (clientv3.LeaseID, error) {
+	resp, err := etcd.client.Grant(context.Background(), ttl)
+	if err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+/**
+ * 同CompareAndSwap,写入的key绑定租约lease;比较失败时在同一请求中返回key的当前值和版本号,
+ * 调用方可以直接据此重试,不需要再读取一次
+ */
+func (etcd *EtcdClient) CompareAndSwapWithLease(key string, revision int64, value string, lease clientv3.LeaseID) (int64, string, int64, error) {
+	resp, err := etcd.client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, value, clientv3.WithLease(lease))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return 0, "", 0, err
+	}
+	if resp.Succeeded {
+		return resp.Header.Revision, "", 0, nil
+	}
+	for _, v := range resp.Responses[0].GetResponseRange().Kvs {
+		return 0, string(v.Value), v.ModRevision, nil
+	}
+	return 0, "", 0, nil
+}
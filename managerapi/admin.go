@@ -1,9 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"skyway/managerapi/auth"
 	"skyway/managerapi/controller"
+	"skyway/managerapi/dao"
+	"time"
 
 	"github.com/buaazp/fasthttprouter"
 	"github.com/valyala/fasthttp"
@@ -29,13 +37,146 @@ func QueryArgs(ctx *fasthttp.RequestCtx) {
 	fmt.Fprintf(ctx, "Pong! %s\n", string(name))
 }
 
+var (
+	listenAddr = flag.String("listen", ":8080", "admin server address")
+	tokenFile  = flag.String("token-file", "", "file of static bearer tokens, one 'name token' per line")
+	tlsCert    = flag.String("tls-cert", "", "server certificate, enables https")
+	tlsKey     = flag.String("tls-key", "", "server private key")
+	clientCA   = flag.String("client-ca", "", "CA bundle for client certificates, enables mTLS authentication")
+	retention  = flag.Duration("audit-retention", 90*24*time.Hour, "how long audit records are kept, 0 keeps them forever")
+)
+
+/**
+ * 根据参数创建监听,配置了证书时使用TLS,配置了客户端CA时校验客户端证书
+ */
+func listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil || *tlsCert == "" {
+		return ln, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if *clientCA != "" {
+		pem, err := ioutil.ReadFile(*clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", *clientCA)
+		}
+		config.ClientCAs = pool
+		//客户端证书是可选的认证方式,未提供证书时仍可使用Token或Basic认证
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tls.NewListener(ln, config), nil
+}
+
 func main() {
+	flag.Parse()
+	if err := DAO.NewRoleBindingDao().MigrateLegacyBindings(); err != nil {
+		log.Fatalf("Error in migrate role bindings: %s", err)
+	}
+	if migrated, err := controller.MigrateLegacyApis(); err != nil {
+		log.Fatalf("Error in migrate legacy apis: %s", err)
+	} else if migrated > 0 {
+		log.Printf("published %d legacy apis to all environments", migrated)
+	}
+	if snapshotted, err := controller.SnapshotPublishedGroups(); err != nil {
+		log.Fatalf("Error in snapshot groups of published apis: %s", err)
+	} else if snapshotted > 0 {
+		log.Printf("snapshotted groups of %d published apis", snapshotted)
+	}
+	if redacted, err := controller.RedactConsumerAudit(); err != nil {
+		log.Fatalf("Error in redact audit records: %s", err)
+	} else if redacted > 0 {
+		log.Printf("redacted credentials in %d consumer audit records", redacted)
+	}
+	if redacted, err := controller.RedactAuthAudit(); err != nil {
+		log.Fatalf("Error in redact audit records: %s", err)
+	} else if redacted > 0 {
+		log.Printf("redacted auth secrets in %d api and group audit records", redacted)
+	}
+	go controller.PruneAudit(*retention)
+	authenticator := auth.New()
+	if *tokenFile != "" {
+		if err := authenticator.LoadTokens(*tokenFile); err != nil {
+			log.Fatalf("Error in load tokens: %s", err)
+		}
+	}
+
+	//分组内的资源在处理函数中按分组检查权限
+	read := func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return auth.RequireAny(auth.PERM_READ, handler)
+	}
+	write := func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return auth.RequireAny(auth.PERM_WRITE, handler)
+	}
+	admin := func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return auth.RequireAny(auth.PERM_ADMIN, handler)
+	}
+	globalAdmin := func(handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return auth.RequireGlobal(auth.PERM_ADMIN, handler)
+	}
+
 	router := fasthttprouter.New()
-	router.GET("/api/register", controller.ApiRegister)
-	router.GET("/hello/:name", Hello)
-	router.GET("/multi/:name/:word", MultiParams)
-	router.GET("/ping", QueryArgs)
+	router.POST("/apis", write(controller.ApiCreate))
+	router.GET("/apis", read(controller.ApiList))
+	router.DELETE("/apis", globalAdmin(controller.ApiDeleteAll))
+	router.GET("/apis/:id", read(controller.ApiGet))
+	router.PUT("/apis/:id", write(controller.ApiUpdate))
+	router.DELETE("/apis/:id", write(controller.ApiDelete))
+	router.GET("/apis/:id/versions", read(controller.ApiVersions))
+	router.GET("/apis/:id/versions/:version", read(controller.ApiVersionGet))
+	router.POST("/apis/:id/versions/:version/rollback", write(controller.ApiRollback))
+	router.POST("/apis/:id/publish", write(controller.ApiPublish))
+	router.POST("/apis/:id/promote", write(controller.ApiPromote))
+	router.POST("/groups", globalAdmin(controller.GroupCreate))
+	router.GET("/groups", read(controller.GroupList))
+	router.GET("/groups/:id", read(controller.GroupGet))
+	router.PUT("/groups/:id", admin(controller.GroupUpdate))
+	router.DELETE("/groups/:id", globalAdmin(controller.GroupDelete))
+	router.GET("/groups/:id/apis", read(controller.GroupApis))
+	router.GET("/environments/:env/apis", read(controller.EnvironmentApiList))
+	router.GET("/environments/:env/apis/:id", read(controller.EnvironmentApiGet))
+	router.DELETE("/environments/:env/apis/:id", write(controller.EnvironmentApiDelete))
+	router.POST("/services", globalAdmin(controller.ServiceCreate))
+	router.GET("/services", read(controller.ServiceList))
+	router.GET("/services/:id", read(controller.ServiceGet))
+	router.PUT("/services/:id", globalAdmin(controller.ServiceUpdate))
+	router.DELETE("/services/:id", globalAdmin(controller.ServiceDelete))
+	router.POST("/consumers", globalAdmin(controller.ConsumerCreate))
+	router.GET("/consumers", globalAdmin(controller.ConsumerList))
+	router.GET("/consumers/:name", globalAdmin(controller.ConsumerGet))
+	router.PUT("/consumers/:name", globalAdmin(controller.ConsumerUpdate))
+	router.DELETE("/consumers/:name", globalAdmin(controller.ConsumerDelete))
+	router.GET("/jwks", globalAdmin(controller.JwksList))
+	router.GET("/jwks/:name", globalAdmin(controller.JwksGet))
+	router.PUT("/jwks/:name", globalAdmin(controller.JwksUpdate))
+	router.DELETE("/jwks/:name", globalAdmin(controller.JwksDelete))
+	router.POST("/users", globalAdmin(controller.UserCreate))
+	router.GET("/users", globalAdmin(controller.UserList))
+	router.PUT("/users/:name", controller.UserUpdate)
+	router.DELETE("/users/:name", globalAdmin(controller.UserDelete))
+	router.GET("/roles", globalAdmin(controller.RoleList))
+	router.GET("/roles/:principal", globalAdmin(controller.RoleGet))
+	router.PUT("/roles/:principal", globalAdmin(controller.RoleUpdate))
+	router.DELETE("/roles/:principal", globalAdmin(controller.RoleDelete))
+	router.GET("/audit", globalAdmin(controller.AuditList))
+	router.GET("/hello/:name", read(Hello))
+	router.GET("/multi/:name/:word", read(MultiParams))
+	router.GET("/ping", read(QueryArgs))
 
-	fmt.Println("starting	web server at	http://localhost:8080/")
-	log.Fatal(fasthttp.ListenAndServe(":8080", router.Handler))
+	ln, err := listen(*listenAddr)
+	if err != nil {
+		log.Fatalf("Error in listen: %s", err)
+	}
+	fmt.Println("starting	web server at", *listenAddr)
+	log.Fatal(fasthttp.Serve(ln, authenticator.Handler(router.Handler)))
 }
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"skyway/library"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * 认证方式
+ */
+const (
+	METHOD_TOKEN = "token"
+	METHOD_BASIC = "basic"
+	METHOD_MTLS  = "mtls"
+)
+
+// 认证通过后保存在ctx中的key
+const principalKey = "auth.principal"
+
+// Basic认证结果缓存时间,避免每个请求都计算bcrypt
+const basicCacheTTL = time.Minute
+
+/**
+ * 已认证的调用方及其角色
+ */
+type Principal struct {
+	Name   string
+	Method string
+	Grants []*model.Grant
+}
+
+/**
+ * 角色绑定中的调用方名称,Basic用户为user:用户名,客户端证书为cert:CommonName
+ */
+func (p *Principal) BindingName() string {
+	if p.Method == METHOD_MTLS {
+		return model.PrincipalName(model.PRINCIPAL_CERT, p.Name)
+	}
+	return model.PrincipalName(model.PRINCIPAL_USER, p.Name)
+}
+
+/**
+ * 获取当前请求已认证的调用方,未认证时返回nil
+ */
+func FromContext(ctx *fasthttp.RequestCtx) *Principal {
+	principal, _ := ctx.UserValue(principalKey).(*Principal)
+	return principal
+}
+
+type basicEntry struct {
+	passwordHash string
+	expires      time.Time
+}
+
+/**
+ * 管理接口认证: 静态Bearer Token,etcd中bcrypt哈希的Basic用户,以及可选的客户端证书(mTLS);
+ * 静态Token由部署者配置,拥有全局admin角色,其他调用方的角色来自etcd中的角色绑定
+ */
+type Authenticator struct {
+	//token -> 名称
+	tokens map[string]string
+
+	mu    sync.Mutex
+	basic map[string]basicEntry
+}
+
+func New() *Authenticator {
+	return &Authenticator{
+		tokens: make(map[string]string),
+		basic:  make(map[string]basicEntry),
+	}
+}
+
+/**
+ * 添加静态Token
+ */
+func (a *Authenticator) AddToken(name string, token string) {
+	a.tokens[token] = name
+}
+
+/**
+ * 从文件加载静态Token,每行"名称 token",#开头为注释
+ */
+func (a *Authenticator) LoadTokens(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected 'name token'", path, line)
+		}
+		a.AddToken(fields[0], fields[1])
+	}
+	return scanner.Err()
+}
+
+/**
+ * 认证当前请求,失败时返回nil
+ */
+func (a *Authenticator) Authenticate(ctx *fasthttp.RequestCtx) *Principal {
+	authorization := ctx.Request.Header.Peek("Authorization")
+	switch {
+	case bytes.HasPrefix(authorization, []byte("Bearer ")):
+		return a.token(string(bytes.TrimSpace(authorization[len("Bearer "):])))
+	case bytes.HasPrefix(authorization, []byte("Basic ")):
+		return a.basicAuth(string(bytes.TrimSpace(authorization[len("Basic "):])))
+	}
+	return a.clientCert(ctx)
+}
+
+func (a *Authenticator) token(token string) *Principal {
+	for known, name := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return &Principal{Name: name, Method: METHOD_TOKEN, Grants: []*model.Grant{{Role: model.ROLE_ADMIN}}}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) basicAuth(encoded string) *Principal {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || username == "" {
+		return nil
+	}
+
+	user, err := DAO.NewAdminUserDao().GetUser(username)
+	if err != nil || user == nil {
+		return nil
+	}
+
+	//缓存以用户名和密码摘要为key,密码修改后哈希变化,缓存自动失效
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	cacheKey := hex.EncodeToString(sum[:])
+	now := time.Now()
+	a.mu.Lock()
+	entry, cached := a.basic[cacheKey]
+	a.mu.Unlock()
+	if cached && entry.passwordHash == user.PasswordHash && now.Before(entry.expires) {
+		return &Principal{Name: username, Method: METHOD_BASIC}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil
+	}
+	a.mu.Lock()
+	for key, item := range a.basic {
+		if now.After(item.expires) {
+			delete(a.basic, key)
+		}
+	}
+	a.basic[cacheKey] = basicEntry{passwordHash: user.PasswordHash, expires: now.Add(basicCacheTTL)}
+	a.mu.Unlock()
+	return &Principal{Name: username, Method: METHOD_BASIC}
+}
+
+/**
+ * 已通过CA校验的客户端证书,以证书的CommonName作为调用方
+ */
+func (a *Authenticator) clientCert(ctx *fasthttp.RequestCtx) *Principal {
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	if name == "" {
+		return nil
+	}
+	return &Principal{Name: name, Method: METHOD_MTLS}
+}
+
+/**
+ * 认证中间件,未通过认证返回401;通过后加载调用方的角色
+ */
+func (a *Authenticator) Handler(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		principal := a.Authenticate(ctx)
+		if principal == nil {
+			ServiceApi.NewDataResponse(ServiceApi.CODE_UNAUTHORIZED, "authentication required").Write(ctx, fasthttp.StatusUnauthorized)
+			ctx.Response.Header.Set("WWW-Authenticate", `Basic realm="skyway"`)
+			ctx.Response.Header.Add("WWW-Authenticate", `Bearer realm="skyway"`)
+			return
+		}
+		if principal.Method != METHOD_TOKEN {
+			binding, err := DAO.NewRoleBindingDao().GetBinding(principal.BindingName())
+			if err != nil {
+				ServiceApi.NewDataResponse(ServiceApi.CODE_INTERNAL_ERROR, err.Error()).Write(ctx, fasthttp.StatusInternalServerError)
+				return
+			}
+			if binding != nil {
+				principal.Grants = binding.Grants
+			}
+		}
+		ctx.SetUserValue(principalKey, principal)
+		next(ctx)
+	}
+}
+
+/**
+ * 哈希密码,用于保存用户
+ */
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
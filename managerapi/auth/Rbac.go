@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"github.com/valyala/fasthttp"
+	"skyway/library"
+	"skyway/managerapi/model"
+)
+
+/**
+ * 操作所需的权限,高级权限包含低级权限
+ */
+type Permission int
+
+const (
+	PERM_READ Permission = iota + 1
+	PERM_WRITE
+	PERM_ADMIN
+)
+
+func rolePermission(role string) Permission {
+	switch role {
+	case model.ROLE_ADMIN:
+		return PERM_ADMIN
+	case model.ROLE_GROUP_OWNER:
+		return PERM_WRITE
+	case model.ROLE_READ_ONLY:
+		return PERM_READ
+	}
+	return 0
+}
+
+func IsRole(role string) bool {
+	return rolePermission(role) > 0
+}
+
+/**
+ * 是否在分组groupId上拥有权限perm;groupId为0表示全局资源,只有全局授权才满足
+ */
+func (p *Principal) Allowed(perm Permission, groupId int) bool {
+	if p == nil {
+		return false
+	}
+	for _, grant := range p.Grants {
+		if (grant.GroupId == 0 || grant.GroupId == groupId) && rolePermission(grant.Role) >= perm {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 是否在任意分组上拥有权限perm
+ */
+func (p *Principal) AllowedAny(perm Permission) bool {
+	if p == nil {
+		return false
+	}
+	for _, grant := range p.Grants {
+		if rolePermission(grant.Role) >= perm {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 输出403
+ */
+func Forbidden(ctx *fasthttp.RequestCtx) {
+	ServiceApi.NewDataResponse(ServiceApi.CODE_FORBIDDEN, "permission denied").Write(ctx, fasthttp.StatusForbidden)
+}
+
+/**
+ * 要求全局权限perm,用于服务,用户,角色等不属于分组的资源
+ */
+func RequireGlobal(perm Permission, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !FromContext(ctx).Allowed(perm, 0) {
+			Forbidden(ctx)
+			return
+		}
+		next(ctx)
+	}
+}
+
+/**
+ * 要求在任意分组上拥有权限perm,具体分组在处理函数中再检查
+ */
+func RequireAny(perm Permission, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !FromContext(ctx).AllowedAny(perm) {
+			Forbidden(ctx)
+			return
+		}
+		next(ctx)
+	}
+}
@@ -3,34 +3,275 @@ package controller
 import (
 	"fmt"
 	"github.com/valyala/fasthttp"
+	"skyway/managerapi/auth"
+	"skyway/managerapi/dao"
 	"skyway/managerapi/model"
-	"strconv"
+	"skyway/managerapi/validator"
+	"sort"
+	"strings"
 )
 
-func ApiRegister(ctx *fasthttp.RequestCtx) {
-	apiName := ctx.QueryArgs().Peek("apiName")
-	apiIdParam := ctx.QueryArgs().Peek("apiId")
-	serviceIdParam := ctx.QueryArgs().Peek("serviceId")
-	apiGroupIdParam := ctx.QueryArgs().Peek("apiGroupId")
-	originUrlPattern := ctx.QueryArgs().Peek("originUrlPattern")
-	destUrlPattern := ctx.QueryArgs().Peek("destUrlPattern")
-	apiDescription := ctx.QueryArgs().Peek("apiDescription")
+/**
+ * 解析请求体中的API定义
+ */
+func parseApi(ctx *fasthttp.RequestCtx) (*model.Api, error) {
+	api := model.NewApi()
+	if err := json.Unmarshal(ctx.PostBody(), api); err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+	api.Method = strings.ToUpper(api.Method)
+	if api.Method == "" {
+		api.Method = "GET"
+	}
+	//修改后需要重新发布;分组快照只在发布时生成
+	api.State = model.API_STATE_DRAFT
+	api.Group = nil
+	return api, nil
+}
+
+/**
+ * 保存前校验API定义,失败时输出400及字段错误;env为空时与草稿比较路由冲突,否则与该环境已发布的API比较
+ */
+func validateApi(ctx *fasthttp.RequestCtx, api *model.Api, env string) bool {
+	services, err := serviceMap()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return false
+	}
+	groups, err := groupMap()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return false
+	}
+	existing, err := apiList(env)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return false
+	}
+	return writeValidation(ctx, validator.ValidateApi(api, services, groups, existing))
+}
 
-	apiId, _ := strconv.Atoi(string(apiIdParam))
-	serviceId, _ := strconv.Atoi(string(serviceIdParam))
-	apiGroupId, _ := strconv.Atoi(string(apiGroupIdParam))
+/**
+ * 全部API,按ID排序;env为空时取草稿,否则取该环境已发布的API
+ */
+func apiList(env string) ([]*model.Api, error) {
+	var apis map[string]*model.Api
+	var err error
+	if env == "" {
+		apis, err = DAO.NewApiDao().GetApis()
+	} else {
+		apis, err = DAO.NewPublishedApiDao(env).GetApis()
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	api := model.NewApi()
+	list := make([]*model.Api, 0, len(apis))
+	for _, api := range apis {
+		list = append(list, api)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ApiId < list[j].ApiId
+	})
+	return list, nil
+}
+
+/**
+ * POST /apis 创建API,未指定ApiId时自动分配
+ */
+func ApiCreate(ctx *fasthttp.RequestCtx) {
+	api, err := parseApi(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	if api.ApiId < 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, "ApiId must not be negative")
+		return
+	}
+	if !authorize(ctx, auth.PERM_WRITE, api.GroupId) {
+		return
+	}
+	//新建时传入的密钥指纹没有意义,不保存
+	api.Auth.KeepSecrets(nil)
+	if !validateApi(ctx, api, "") {
+		return
+	}
+
+	apiDao := DAO.NewApiDao()
+	if api.ApiId == 0 {
+		api.ApiId, err = apiDao.NextApiId()
+		if err != nil {
+			writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	revision, err := apiDao.CreateApi(api, operator(ctx))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision == 0 {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("api %d already exists", api.ApiId))
+		return
+	}
+	setETag(ctx, revision)
+	audit(ctx, "api.create", fmt.Sprintf("apis/%d", api.ApiId), nil, api.Redacted())
+	writeJson(ctx, fasthttp.StatusCreated, api.Redacted())
+}
+
+/**
+ * GET /apis 当前调用方可读的API列表,按ID排序
+ */
+func ApiList(ctx *fasthttp.RequestCtx) {
+	list, err := apiList("")
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJson(ctx, fasthttp.StatusOK, readableApis(ctx, list))
+}
+
+/**
+ * GET /apis/:id API详情,版本号通过ETag返回;认证密钥只返回指纹
+ */
+func ApiGet(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	api, revision, err := DAO.NewApiDao().GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if api == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("api %d not found", apiId))
+		return
+	}
+	if !authorize(ctx, auth.PERM_READ, api.GroupId) {
+		return
+	}
+	setETag(ctx, revision)
+	writeJson(ctx, fasthttp.StatusOK, api.Redacted())
+}
+
+/**
+ * PUT /apis/:id 更新API,必须传入If-Match,仅当版本号一致才更新,否则返回412;
+ * 认证密钥只写,不传密钥而原样传回读取时的指纹表示不修改密钥
+ */
+func ApiUpdate(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	expected, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+	api, err := parseApi(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
 	api.ApiId = apiId
-	api.ApiName = string(apiName)
-	api.ServiceId = serviceId
-	api.GroupId = apiGroupId
-	api.OriginUriPattern = string(originUrlPattern)
-	api.DestUriPattern = string(destUrlPattern)
-	api.ApiDescription = string(apiDescription)
-
-	//apiName := ctx.UserValue("apiName")
-	fmt.Fprint(ctx, string(apiId))
-	fmt.Fprint(ctx, string(apiName))
-	fmt.Fprint(ctx, "Welcome Register!\n", )
+
+	apiDao := DAO.NewApiDao()
+	exist, current, err := apiDao.GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("api %d not found", apiId))
+		return
+	}
+	//移动到其他分组时需要两个分组的权限
+	if !authorize(ctx, auth.PERM_WRITE, exist.GroupId, api.GroupId) {
+		return
+	}
+	if expected != current {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d has been modified, current revision is %d", apiId, current))
+		return
+	}
+	api.Auth.KeepSecrets(exist.Auth)
+	if !validateApi(ctx, api, "") {
+		return
+	}
+
+	//以客户端传入的版本号写入,期间被其他请求修改则失败
+	revision, err := apiDao.UpdateApi(api, exist, expected, operator(ctx))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision == 0 {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d has been modified concurrently", apiId))
+		return
+	}
+	setETag(ctx, revision)
+	audit(ctx, "api.update", fmt.Sprintf("apis/%d", apiId), exist.Redacted(), api.Redacted())
+	writeJson(ctx, fasthttp.StatusOK, api.Redacted())
+}
+
+/**
+ * DELETE /apis/:id 删除API,必须传入If-Match,仅当版本号一致才删除,否则返回412
+ */
+func ApiDelete(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	expected, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+
+	apiDao := DAO.NewApiDao()
+	exist, current, err := apiDao.GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("api %d not found", apiId))
+		return
+	}
+	if !authorize(ctx, auth.PERM_WRITE, exist.GroupId) {
+		return
+	}
+	if expected != current {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d has been modified, current revision is %d", apiId, current))
+		return
+	}
+
+	deleted, err := apiDao.DelApiIfRevision(exist, expected, operator(ctx))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d has been modified concurrently", apiId))
+		return
+	}
+	audit(ctx, "api.delete", fmt.Sprintf("apis/%d", apiId), exist.Redacted(), nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+/**
+ * DELETE /apis 删除全部API草稿,已发布到各环境的API不受影响
+ */
+func ApiDeleteAll(ctx *fasthttp.RequestCtx) {
+	list, err := apiList("")
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := DAO.NewApiDao().DelAllApis(); err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	audit(ctx, "api.delete_all", "apis", redactedApis(list), nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
 }
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"skyway/managerapi/auth"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+)
+
+/**
+ * GET /apis/:id/versions API的历史版本,按版本号升序;认证密钥只返回指纹
+ */
+func ApiVersions(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	versions, err := DAO.NewApiHistoryDao().GetVersions(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(versions) == 0 {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("no history for api %d", apiId))
+		return
+	}
+	if !authorize(ctx, auth.PERM_READ, versions[len(versions)-1].GroupId()) {
+		return
+	}
+	list := make([]*model.ApiVersion, 0, len(versions))
+	for _, apiVersion := range versions {
+		list = append(list, redactedVersion(apiVersion))
+	}
+	writeJson(ctx, fasthttp.StatusOK, list)
+}
+
+/**
+ * GET /apis/:id/versions/:version 指定历史版本,认证密钥只返回指纹
+ */
+func ApiVersionGet(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	version, ok := idParam(ctx, "version")
+	if !ok {
+		return
+	}
+
+	apiVersion, err := DAO.NewApiHistoryDao().GetVersion(apiId, int64(version))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if apiVersion == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("version %d of api %d not found", version, apiId))
+		return
+	}
+	if !authorize(ctx, auth.PERM_READ, apiVersion.GroupId()) {
+		return
+	}
+	writeJson(ctx, fasthttp.StatusOK, redactedVersion(apiVersion))
+}
+
+/**
+ * 隐去历史版本中的认证密钥;历史中保存原文,回滚时恢复。
+ * Changes中的值是解码后的JSON,Auth和Group字段重新解析后隐去
+ */
+func redactedVersion(apiVersion *model.ApiVersion) *model.ApiVersion {
+	redacted := apiVersion.Redacted()
+	redacted.Changes = make([]*model.FieldChange, 0, len(apiVersion.Changes))
+	for _, change := range apiVersion.Changes {
+		redacted.Changes = append(redacted.Changes, &model.FieldChange{
+			Field:  change.Field,
+			Before: redactedField(change.Field, change.Before),
+			After:  redactedField(change.Field, change.After),
+		})
+	}
+	return redacted
+}
+
+func redactedField(field string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch field {
+	case "Auth":
+		policy := &model.AuthPolicy{}
+		if !decodeAs(value, policy) {
+			return nil
+		}
+		return policy.Redacted()
+	case "Group":
+		group := model.NewGroup()
+		if !decodeAs(value, group) {
+			return nil
+		}
+		return group.Redacted()
+	}
+	return value
+}
+
+/**
+ * POST /apis/:id/versions/:version/rollback 将API恢复为指定版本变更后的内容,
+ * 已删除的API会被重新创建;回滚本身也作为一个新版本记录,回滚后的草稿需要重新发布
+ */
+func ApiRollback(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	version, ok := idParam(ctx, "version")
+	if !ok {
+		return
+	}
+
+	apiVersion, err := DAO.NewApiHistoryDao().GetVersion(apiId, int64(version))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if apiVersion == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("version %d of api %d not found", version, apiId))
+		return
+	}
+	if apiVersion.Current == nil {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("version %d of api %d is a deletion, nothing to roll back to", version, apiId))
+		return
+	}
+
+	apiDao := DAO.NewApiDao()
+	exist, current, err := apiDao.GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if !authorize(ctx, auth.PERM_WRITE, apiVersion.GroupId()) {
+		return
+	}
+	if exist != nil && !authorize(ctx, auth.PERM_WRITE, exist.GroupId) {
+		return
+	}
+	//已删除的API没有可匹配的版本号,按key不存在写入
+	var expected int64
+	if exist != nil || len(ctx.Request.Header.Peek("If-Match")) > 0 {
+		if expected, ok = ifMatch(ctx); !ok {
+			return
+		}
+	}
+	if expected != current {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d has been modified, current revision is %d", apiId, current))
+		return
+	}
+
+	//历史版本的路由可能已与后来新增的API冲突,回滚前重新校验
+	api := apiVersion.Current
+	api.State = model.API_STATE_DRAFT
+	//发布版本中带有分组快照,草稿使用分组的当前设置
+	api.Group = nil
+	if !validateApi(ctx, api, "") {
+		return
+	}
+
+	revision, err := apiDao.RollbackApi(api, exist, expected, int64(version), operator(ctx))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision == 0 {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d has been modified concurrently", apiId))
+		return
+	}
+	setETag(ctx, revision)
+	audit(ctx, "api.rollback", fmt.Sprintf("apis/%d", apiId), exist.Redacted(), api.Redacted())
+	writeJson(ctx, fasthttp.StatusOK, api.Redacted())
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"github.com/valyala/fasthttp"
+	"log"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//查询审计记录默认返回的条数
+	defaultAuditLimit = 100
+	//管理服务自身执行修改时记录的操作人
+	systemOperator = "system"
+	//清理过期审计记录的间隔
+	auditPruneInterval = time.Hour
+)
+
+/**
+ * 记录一次成功的修改操作;写入失败只记日志,不影响已完成的修改
+ */
+func audit(ctx *fasthttp.RequestCtx, action string, resource string, before interface{}, after interface{}) {
+	writeAudit(&model.AuditRecord{
+		Time:     time.Now(),
+		Actor:    operator(ctx),
+		SourceIp: ctx.RemoteIP().String(),
+		Action:   action,
+		Resource: resource,
+		Before:   before,
+		After:    after,
+	})
+}
+
+/**
+ * 记录管理服务自身执行的修改,如启动时的升级迁移
+ */
+func systemAudit(action string, resource string, before interface{}, after interface{}) {
+	writeAudit(&model.AuditRecord{
+		Time:     time.Now(),
+		Actor:    systemOperator,
+		Action:   action,
+		Resource: resource,
+		Before:   before,
+		After:    after,
+	})
+}
+
+func writeAudit(record *model.AuditRecord) {
+	if err := DAO.NewAuditDao().AddRecord(record); err != nil {
+		log.Printf("write audit record %s %s by %s failed: %s", record.Action, record.Resource, record.Actor, err)
+	}
+}
+
+/**
+ * 解析时间参数,支持RFC3339和Unix秒
+ */
+func timeArg(ctx *fasthttp.RequestCtx, name string, defaultTime time.Time) (time.Time, bool) {
+	value := string(ctx.QueryArgs().Peek(name))
+	if value == "" {
+		return defaultTime, true
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, "invalid "+name+" '"+value+"', expect RFC3339 or unix seconds")
+		return t, false
+	}
+	return t, true
+}
+
+/**
+ * GET /audit?actor=&resource=&from=&to=&limit= 查询审计记录,按时间倒序;
+ * from默认为7天前,to默认为当前时间,limit默认100
+ */
+func AuditList(ctx *fasthttp.RequestCtx) {
+	now := time.Now()
+	from, ok := timeArg(ctx, "from", now.AddDate(0, 0, -7))
+	if !ok {
+		return
+	}
+	to, ok := timeArg(ctx, "to", now.Add(time.Second))
+	if !ok {
+		return
+	}
+	limit := defaultAuditLimit
+	if value := ctx.QueryArgs().Peek("limit"); len(value) > 0 {
+		n, err := strconv.Atoi(string(value))
+		if err != nil || n <= 0 {
+			writeError(ctx, fasthttp.StatusBadRequest, "invalid limit '"+string(value)+"'")
+			return
+		}
+		limit = n
+	}
+	actor := string(ctx.QueryArgs().Peek("actor"))
+	resource := string(ctx.QueryArgs().Peek("resource"))
+
+	list, err := DAO.NewAuditDao().GetRecords(from, to, limit, func(record *model.AuditRecord) bool {
+		return (actor == "" || record.Actor == actor) && (resource == "" || record.Resource == resource)
+	})
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJson(ctx, fasthttp.StatusOK, list)
+}
+
+/**
+ * 定期删除超过保留时间retention的审计记录,启动时执行一次,之后每隔auditPruneInterval执行;
+ * retention为0时永久保留。多个管理服务实例同时执行时结果相同
+ */
+func PruneAudit(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	for {
+		deleted, err := DAO.NewAuditDao().DelRecordsBefore(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("prune audit records failed: %s", err)
+		} else if deleted > 0 {
+			log.Printf("pruned %d audit records older than %s", deleted, retention)
+		}
+		time.Sleep(auditPruneInterval)
+	}
+}
+
+/**
+ * 升级迁移: 隐去修复前写入的API和分组审计记录中的认证密钥,返回修改的记录数
+ */
+func RedactAuthAudit() (int, error) {
+	redacted := 0
+	for _, prefix := range []string{"api.", "group."} {
+		count, err := DAO.NewAuditDao().RewriteRecords(prefix, func(record *model.AuditRecord) bool {
+			before, redactedBefore := redactAuditAuth(record.Action, record.Before)
+			after, redactedAfter := redactAuditAuth(record.Action, record.After)
+			record.Before, record.After = before, after
+			return redactedBefore || redactedAfter
+		})
+		redacted += count
+		if err != nil {
+			return redacted, err
+		}
+	}
+	return redacted, nil
+}
+
+/**
+ * 审计记录中解码出的API,API列表或分组含有认证密钥时转为隐去密钥的副本
+ */
+func redactAuditAuth(action string, value interface{}) (interface{}, bool) {
+	if value == nil {
+		return value, false
+	}
+	switch {
+	case action == "api.delete_all":
+		apis := make([]*model.Api, 0)
+		if !decodeAs(value, &apis) {
+			return value, false
+		}
+		for _, api := range apis {
+			if api.HasSecrets() {
+				return redactedApis(apis), true
+			}
+		}
+	case strings.HasPrefix(action, "group."):
+		group := model.NewGroup()
+		if decodeAs(value, group) && group.HasSecrets() {
+			return group.Redacted(), true
+		}
+	default:
+		api := model.NewApi()
+		if decodeAs(value, api) && api.HasSecrets() {
+			return api.Redacted(), true
+		}
+	}
+	return value, false
+}
+
+/**
+ * 把解码出的JSON值重新解析为target,失败时返回false
+ */
+func decodeAs(value interface{}, target interface{}) bool {
+	data, err := json.Marshal(value)
+	return err == nil && json.Unmarshal(data, target) == nil
+}
@@ -0,0 +1,321 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"sort"
+)
+
+// 手工指定的API Key的最小长度
+const minApiKeyLength = 16
+
+/**
+ * 生成随机API Key
+ */
+func newApiKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+/**
+ * 审计记录中的调用方,不保存API Key和HMAC密钥原文:API Key只记录指纹,HMAC密钥只记录KeyId
+ */
+type consumerView struct {
+	ConsumerName       string
+	Description        string
+	ApiKeyFingerprints []string
+	HmacKeyIds         []string
+	GroupIds           []int
+	ApiIds             []int
+}
+
+func newConsumerView(consumer *model.Consumer) *consumerView {
+	view := &consumerView{
+		ConsumerName:       consumer.ConsumerName,
+		Description:        consumer.Description,
+		ApiKeyFingerprints: make([]string, 0, len(consumer.ApiKeys)),
+		HmacKeyIds:         make([]string, 0, len(consumer.HmacKeys)),
+		GroupIds:           consumer.GroupIds,
+		ApiIds:             consumer.ApiIds,
+	}
+	for _, key := range consumer.ApiKeys {
+		view.ApiKeyFingerprints = append(view.ApiKeyFingerprints, model.Fingerprint(key))
+	}
+	for _, hmacKey := range consumer.HmacKeys {
+		if hmacKey != nil {
+			view.HmacKeyIds = append(view.HmacKeyIds, hmacKey.KeyId)
+		}
+	}
+	return view
+}
+
+/**
+ * 升级迁移: 脱敏修复前写入的调用方审计记录,返回修改的记录数
+ */
+func RedactConsumerAudit() (int, error) {
+	return DAO.NewAuditDao().RewriteRecords("consumer.", func(record *model.AuditRecord) bool {
+		before, redactedBefore := redactConsumer(record.Before)
+		after, redactedAfter := redactConsumer(record.After)
+		record.Before, record.After = before, after
+		return redactedBefore || redactedAfter
+	})
+}
+
+/**
+ * 审计记录中解码出的调用方含有ApiKeys或HmacKeys时转为consumerView
+ */
+func redactConsumer(value interface{}) (interface{}, bool) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return value, false
+	}
+	_, hasApiKeys := fields["ApiKeys"]
+	_, hasHmacKeys := fields["HmacKeys"]
+	if !hasApiKeys && !hasHmacKeys {
+		return value, false
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return value, false
+	}
+	consumer := model.NewConsumer()
+	if err := json.Unmarshal(data, consumer); err != nil {
+		//无法解析时整体丢弃,不保留密钥
+		return nil, true
+	}
+	return newConsumerView(consumer), true
+}
+
+/**
+ * 解析请求体中的调用方定义,未指定API Key时生成一个
+ */
+func parseConsumer(ctx *fasthttp.RequestCtx) (*model.Consumer, error) {
+	consumer := model.NewConsumer()
+	if err := json.Unmarshal(ctx.PostBody(), consumer); err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+	if len(consumer.ApiKeys) == 0 {
+		key, err := newApiKey()
+		if err != nil {
+			return nil, err
+		}
+		consumer.ApiKeys = []string{key}
+	}
+	return consumer, nil
+}
+
+/**
+ * 保存前校验调用方: API Key和HMAC密钥的格式及不与其他调用方重复,授权的分组和API存在;失败时输出错误
+ */
+func validateConsumer(ctx *fasthttp.RequestCtx, consumer *model.Consumer) bool {
+	if !usernameRegexp.MatchString(consumer.ConsumerName) {
+		writeError(ctx, fasthttp.StatusBadRequest, "ConsumerName must be 1-64 letters, digits or _.@-")
+		return false
+	}
+	for i, key := range consumer.ApiKeys {
+		if len(key) < minApiKeyLength {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("ApiKeys[%d] must have at least %d characters", i, minApiKeyLength))
+			return false
+		}
+	}
+
+	keyIds := make(map[string]bool, len(consumer.HmacKeys))
+	for i, hmacKey := range consumer.HmacKeys {
+		if hmacKey == nil || !usernameRegexp.MatchString(hmacKey.KeyId) {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("HmacKeys[%d].KeyId must be 1-64 letters, digits or _.@-", i))
+			return false
+		}
+		if len(hmacKey.Secret) < minApiKeyLength {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("HmacKeys[%d].Secret must have at least %d characters", i, minApiKeyLength))
+			return false
+		}
+		if keyIds[hmacKey.KeyId] {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("HmacKeys[%d].KeyId %s is duplicated", i, hmacKey.KeyId))
+			return false
+		}
+		keyIds[hmacKey.KeyId] = true
+	}
+
+	groups, err := groupMap()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return false
+	}
+	for i, groupId := range consumer.GroupIds {
+		if groups[groupId] == nil {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("GroupIds[%d]: group %d not found", i, groupId))
+			return false
+		}
+	}
+	apiDao := DAO.NewApiDao()
+	for i, apiId := range consumer.ApiIds {
+		api, err := apiDao.GetApi(apiId)
+		if err != nil {
+			writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+			return false
+		}
+		if api == nil {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("ApiIds[%d]: api %d not found", i, apiId))
+			return false
+		}
+	}
+
+	//网关按API Key和HMAC KeyId查找调用方,不能被多个调用方共用
+	consumers, err := DAO.NewConsumerDao().GetConsumers()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return false
+	}
+	for _, other := range consumers {
+		if other.ConsumerName == consumer.ConsumerName {
+			continue
+		}
+		for _, key := range other.ApiKeys {
+			for _, own := range consumer.ApiKeys {
+				if key == own {
+					writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("api key is already used by consumer %s", other.ConsumerName))
+					return false
+				}
+			}
+		}
+		for _, hmacKey := range other.HmacKeys {
+			if hmacKey != nil && keyIds[hmacKey.KeyId] {
+				writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("hmac key id %s is already used by consumer %s", hmacKey.KeyId, other.ConsumerName))
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/**
+ * POST /consumers 创建调用方,未指定ApiKeys时生成一个
+ */
+func ConsumerCreate(ctx *fasthttp.RequestCtx) {
+	consumer, err := parseConsumer(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	if !validateConsumer(ctx, consumer) {
+		return
+	}
+
+	consumerDao := DAO.NewConsumerDao()
+	exist, err := consumerDao.GetConsumer(consumer.ConsumerName)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist != nil {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("consumer %s already exists", consumer.ConsumerName))
+		return
+	}
+	if !consumerDao.RegisterConsumer(consumer) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save consumer failed")
+		return
+	}
+	audit(ctx, "consumer.create", "consumers/"+consumer.ConsumerName, nil, newConsumerView(consumer))
+	writeJson(ctx, fasthttp.StatusCreated, consumer)
+}
+
+/**
+ * GET /consumers 调用方列表,按名称排序
+ */
+func ConsumerList(ctx *fasthttp.RequestCtx) {
+	consumers, err := DAO.NewConsumerDao().GetConsumers()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	list := make([]*model.Consumer, 0, len(consumers))
+	for _, consumer := range consumers {
+		list = append(list, consumer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ConsumerName < list[j].ConsumerName
+	})
+	writeJson(ctx, fasthttp.StatusOK, list)
+}
+
+/**
+ * GET /consumers/:name 调用方详情
+ */
+func ConsumerGet(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("name").(string)
+	consumer, err := DAO.NewConsumerDao().GetConsumer(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if consumer == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("consumer %s not found", name))
+		return
+	}
+	writeJson(ctx, fasthttp.StatusOK, consumer)
+}
+
+/**
+ * PUT /consumers/:name 更新调用方,未指定ApiKeys时生成新Key替换原有的Key
+ */
+func ConsumerUpdate(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("name").(string)
+	consumer, err := parseConsumer(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	consumer.ConsumerName = name
+	if !validateConsumer(ctx, consumer) {
+		return
+	}
+
+	consumerDao := DAO.NewConsumerDao()
+	exist, err := consumerDao.GetConsumer(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("consumer %s not found", name))
+		return
+	}
+	if !consumerDao.RegisterConsumer(consumer) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save consumer failed")
+		return
+	}
+	audit(ctx, "consumer.update", "consumers/"+name, newConsumerView(exist), newConsumerView(consumer))
+	writeJson(ctx, fasthttp.StatusOK, consumer)
+}
+
+/**
+ * DELETE /consumers/:name 删除调用方,其API Key立即失效
+ */
+func ConsumerDelete(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("name").(string)
+	consumerDao := DAO.NewConsumerDao()
+	exist, err := consumerDao.GetConsumer(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	deleted, err := consumerDao.DelConsumer(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("consumer %s not found", name))
+		return
+	}
+	audit(ctx, "consumer.delete", "consumers/"+name, newConsumerView(exist), nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
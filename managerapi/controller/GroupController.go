@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"skyway/managerapi/auth"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"skyway/managerapi/validator"
+	"sort"
+)
+
+/**
+ * 全部分组,按ID索引
+ */
+func groupMap() (map[int]*model.Group, error) {
+	groups, err := DAO.NewGroupDao().GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]*model.Group, len(groups))
+	for _, group := range groups {
+		result[group.GroupId] = group
+	}
+	return result, nil
+}
+
+/**
+ * 解析请求体中的分组定义
+ */
+func parseGroup(ctx *fasthttp.RequestCtx) (*model.Group, error) {
+	group := model.NewGroup()
+	if err := json.Unmarshal(ctx.PostBody(), group); err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+	return group, nil
+}
+
+/**
+ * 保存前校验分组,包括组内草稿和各环境已发布的API合并新设置后的路由,失败时输出400及字段错误
+ */
+func validateGroup(ctx *fasthttp.RequestCtx, group *model.Group) bool {
+	services, err := serviceMap()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !writeValidation(ctx, validator.ValidateGroup(group, services)) {
+		return false
+	}
+
+	groups, err := groupMap()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return false
+	}
+	apis := make(map[string][]*model.Api, len(model.Environments)+1)
+	for _, env := range append([]string{""}, model.Environments...) {
+		list, err := apiList(env)
+		if err != nil {
+			writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+			return false
+		}
+		apis[env] = list
+	}
+	return writeValidation(ctx, validator.ValidateGroupApis(group, services, groups, apis))
+}
+
+/**
+ * POST /groups 创建分组
+ */
+func GroupCreate(ctx *fasthttp.RequestCtx) {
+	group, err := parseGroup(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	if group.GroupId <= 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, "GroupId is required")
+		return
+	}
+
+	groupDao := DAO.NewGroupDao()
+	exist, err := groupDao.GetGroup(group.GroupId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist != nil {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("group %d already exists", group.GroupId))
+		return
+	}
+	//新建时传入的密钥指纹没有意义,不保存
+	group.Auth.KeepSecrets(nil)
+	if !validateGroup(ctx, group) {
+		return
+	}
+	//以key不存在为条件写入,并发创建同一ID时只有一个成功
+	revision, err := groupDao.CreateGroup(group)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision == 0 {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("group %d already exists", group.GroupId))
+		return
+	}
+	setETag(ctx, revision)
+	audit(ctx, "group.create", fmt.Sprintf("groups/%d", group.GroupId), nil, group.Redacted())
+	writeJson(ctx, fasthttp.StatusCreated, group.Redacted())
+}
+
+/**
+ * GET /groups 当前调用方可读的分组列表,按ID排序
+ */
+func GroupList(ctx *fasthttp.RequestCtx) {
+	groups, err := DAO.NewGroupDao().GetGroups()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	principal := auth.FromContext(ctx)
+	list := make([]*model.Group, 0, len(groups))
+	for _, group := range groups {
+		if principal.Allowed(auth.PERM_READ, group.GroupId) {
+			list = append(list, group)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].GroupId < list[j].GroupId
+	})
+	writeJson(ctx, fasthttp.StatusOK, redactedGroups(list))
+}
+
+/**
+ * GET /groups/:id 分组详情,版本号通过ETag返回
+ */
+func GroupGet(ctx *fasthttp.RequestCtx) {
+	groupId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	if !authorize(ctx, auth.PERM_READ, groupId) {
+		return
+	}
+
+	group, revision, err := DAO.NewGroupDao().GetGroupWithRevision(groupId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if group == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("group %d not found", groupId))
+		return
+	}
+	setETag(ctx, revision)
+	writeJson(ctx, fasthttp.StatusOK, group.Redacted())
+}
+
+/**
+ * PUT /groups/:id 更新分组,组内已发布的API保留发布时的快照,重新发布后才使用新设置;
+ * 必须传入If-Match,仅当版本号一致才更新,否则返回412;认证密钥的规则同ApiUpdate
+ */
+func GroupUpdate(ctx *fasthttp.RequestCtx) {
+	groupId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	expected, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+	group, err := parseGroup(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	group.GroupId = groupId
+	if !authorize(ctx, auth.PERM_ADMIN, groupId) {
+		return
+	}
+
+	groupDao := DAO.NewGroupDao()
+	exist, current, err := groupDao.GetGroupWithRevision(groupId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("group %d not found", groupId))
+		return
+	}
+	if expected != current {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("group %d has been modified, current revision is %d", groupId, current))
+		return
+	}
+	group.Auth.KeepSecrets(exist.Auth)
+	if !validateGroup(ctx, group) {
+		return
+	}
+
+	//以客户端传入的版本号写入,期间被其他请求修改则失败
+	revision, err := groupDao.UpdateGroup(group, expected)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision == 0 {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("group %d has been modified concurrently", groupId))
+		return
+	}
+	setETag(ctx, revision)
+	audit(ctx, "group.update", fmt.Sprintf("groups/%d", groupId), exist.Redacted(), group.Redacted())
+	writeJson(ctx, fasthttp.StatusOK, group.Redacted())
+}
+
+/**
+ * DELETE /groups/:id 删除分组,仍有草稿或已发布的API属于该分组时返回409;
+ * 必须传入If-Match,仅当版本号一致才删除,否则返回412
+ */
+func GroupDelete(ctx *fasthttp.RequestCtx) {
+	groupId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	expected, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+
+	groupDao := DAO.NewGroupDao()
+	exist, current, err := groupDao.GetGroupWithRevision(groupId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("group %d not found", groupId))
+		return
+	}
+	if expected != current {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("group %d has been modified, current revision is %d", groupId, current))
+		return
+	}
+
+	//检查组内API和删除在同一事务中完成
+	deleted, usedBy, err := groupDao.DelGroupIfUnused(groupId, expected)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if usedBy > 0 {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("group %d is still used by api %d", groupId, usedBy))
+		return
+	}
+	if !deleted {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("group %d or its apis have been modified concurrently", groupId))
+		return
+	}
+	audit(ctx, "group.delete", fmt.Sprintf("groups/%d", groupId), exist.Redacted(), nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+/**
+ * GET /groups/:id/apis?env=prod 分组内的API,按ID排序;未指定env时返回草稿
+ */
+func GroupApis(ctx *fasthttp.RequestCtx) {
+	groupId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	if !authorize(ctx, auth.PERM_READ, groupId) {
+		return
+	}
+	env := ""
+	if len(ctx.QueryArgs().Peek("env")) > 0 {
+		if env, ok = envParam(ctx, "env", ""); !ok {
+			return
+		}
+	}
+
+	group, err := DAO.NewGroupDao().GetGroup(groupId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if group == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("group %d not found", groupId))
+		return
+	}
+
+	apis, err := apiList(env)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	list := make([]*model.Api, 0)
+	for _, api := range apis {
+		if api.GroupId == groupId {
+			list = append(list, api.Redacted())
+		}
+	}
+	writeJson(ctx, fasthttp.StatusOK, list)
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"skyway/gateway/skyjwt"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"sort"
+)
+
+/**
+ * 认证配置是否引用指定JWKS
+ */
+func usesJwks(auth *model.AuthPolicy, name string) bool {
+	return auth != nil && auth.Jwt != nil && auth.Jwt.JwksName == name
+}
+
+/**
+ * 查找引用指定JWKS的分组或API,包括草稿和各环境已发布的API;没有时返回空字符串
+ */
+func jwksUser(name string) (string, error) {
+	groups, err := DAO.NewGroupDao().GetGroups()
+	if err != nil {
+		return "", err
+	}
+	for _, group := range groups {
+		if usesJwks(group.Auth, name) {
+			return fmt.Sprintf("group %d", group.GroupId), nil
+		}
+	}
+	for _, env := range append([]string{""}, model.Environments...) {
+		apis, err := apiList(env)
+		if err != nil {
+			return "", err
+		}
+		for _, api := range apis {
+			if usesJwks(api.Auth, name) {
+				return fmt.Sprintf("api %d", api.ApiId), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+/**
+ * GET /jwks 全部JWKS名称,按名称排序
+ */
+func JwksList(ctx *fasthttp.RequestCtx) {
+	all, err := DAO.NewJwksDao().GetAllJwks()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeJson(ctx, fasthttp.StatusOK, names)
+}
+
+/**
+ * GET /jwks/:name JWKS原文
+ */
+func JwksGet(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("name").(string)
+	jwks, err := DAO.NewJwksDao().GetJwks(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if jwks == "" {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("jwks %s not found", name))
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.SetBodyString(jwks)
+}
+
+/**
+ * PUT /jwks/:name 创建或替换JWKS,请求体为JWKS原文,网关自动加载
+ */
+func JwksUpdate(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("name").(string)
+	if !usernameRegexp.MatchString(name) {
+		writeError(ctx, fasthttp.StatusBadRequest, "name must be 1-64 letters, digits or _.@-")
+		return
+	}
+	set, err := skyjwt.ParseJwks(ctx.PostBody())
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	if len(set.Keys) == 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, "jwks has no signing keys")
+		return
+	}
+
+	jwksDao := DAO.NewJwksDao()
+	previous, err := jwksDao.GetJwks(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	jwks := string(ctx.PostBody())
+	if !jwksDao.RegisterJwks(name, jwks) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save jwks failed")
+		return
+	}
+	if previous == "" {
+		audit(ctx, "jwks.create", "jwks/"+name, nil, jwks)
+	} else {
+		audit(ctx, "jwks.update", "jwks/"+name, previous, jwks)
+	}
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+/**
+ * DELETE /jwks/:name 删除JWKS,仍被分组或API引用时返回409
+ */
+func JwksDelete(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue("name").(string)
+	user, err := jwksUser(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if user != "" {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("jwks %s is still used by %s", name, user))
+		return
+	}
+
+	jwksDao := DAO.NewJwksDao()
+	previous, err := jwksDao.GetJwks(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	deleted, err := jwksDao.DelJwks(name)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("jwks %s not found", name))
+		return
+	}
+	audit(ctx, "jwks.delete", "jwks/"+name, previous, nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
@@ -0,0 +1,365 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"log"
+	"skyway/managerapi/auth"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"skyway/managerapi/validator"
+	"strings"
+)
+
+/**
+ * 读取环境参数,优先取路由参数,其次取QueryString;为空时使用defaultEnv,非法时输出400
+ */
+func envParam(ctx *fasthttp.RequestCtx, name string, defaultEnv string) (string, bool) {
+	env, _ := ctx.UserValue(name).(string)
+	if env == "" {
+		env = string(ctx.QueryArgs().Peek(name))
+	}
+	if env == "" {
+		env = defaultEnv
+	}
+	if !model.IsEnvironment(env) {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid %s '%s', must be one of %s", name, env, strings.Join(model.Environments, ",")))
+		return "", false
+	}
+	return env, true
+}
+
+/**
+ * POST /apis/:id/publish?env=test 发布草稿到指定环境,默认第一个环境,同时保存所属分组设置的快照;
+ * 必须传入If-Match,仅当草稿版本号一致才发布
+ */
+func ApiPublish(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	env, ok := envParam(ctx, "env", model.Environments[0])
+	if !ok {
+		return
+	}
+	expected, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+
+	apiDao := DAO.NewApiDao()
+	draft, current, err := apiDao.GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if draft == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("api %d not found", apiId))
+		return
+	}
+	if !authorize(ctx, auth.PERM_WRITE, draft.GroupId) {
+		return
+	}
+	if expected != current {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d has been modified, current revision is %d", apiId, current))
+		return
+	}
+	//先读取分组再校验,提交时分组版本号变化则失败,快照与校验时的设置一致
+	group, groupRevision, err := DAO.NewGroupDao().GetGroupWithRevision(draft.GroupId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if !validateApi(ctx, draft, env) {
+		return
+	}
+	previous, err := DAO.NewPublishedApiDao(env).GetApi(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	published := draft.Published(group)
+	revision, err := apiDao.PublishApi(published, draft, expected, groupRevision, env, operator(ctx))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision == 0 {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d or its group has been modified concurrently", apiId))
+		return
+	}
+	draft.State = model.API_STATE_PUBLISHED
+	setETag(ctx, revision)
+	audit(ctx, "api.publish", fmt.Sprintf("environments/%s/apis/%d", env, apiId), previous.Redacted(), published.Redacted())
+	writeJson(ctx, fasthttp.StatusOK, draft.Redacted())
+}
+
+/**
+ * POST /apis/:id/promote?from=test&to=staging 将环境from中已发布的API晋级到环境to,
+ * to为空时晋级到下一个环境;分组快照随API一起复制,目标环境使用与from中相同的分组设置
+ */
+func ApiPromote(ctx *fasthttp.RequestCtx) {
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	from, ok := envParam(ctx, "from", "")
+	if !ok {
+		return
+	}
+	to, ok := envParam(ctx, "to", model.NextEnvironment(from))
+	if !ok {
+		return
+	}
+	if from == to {
+		writeError(ctx, fasthttp.StatusBadRequest, "from and to must be different environments")
+		return
+	}
+
+	api, err := DAO.NewPublishedApiDao(from).GetApi(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if api == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("api %d is not published in %s", apiId, from))
+		return
+	}
+	if !authorize(ctx, auth.PERM_WRITE, api.GroupId) {
+		return
+	}
+	if !validateApi(ctx, api, to) {
+		return
+	}
+	previous, current, err := DAO.NewPublishedApiDao(to).GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	//以读取时目标环境的版本号写入,期间有其他发布或晋级则失败
+	revision, err := DAO.NewApiDao().PromoteApi(api, previous, current, to, operator(ctx))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision == 0 {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d in %s has been modified concurrently", apiId, to))
+		return
+	}
+	audit(ctx, "api.promote", fmt.Sprintf("environments/%s/apis/%d", to, apiId), previous.Redacted(), api.Redacted())
+	writeJson(ctx, fasthttp.StatusOK, api.Redacted())
+}
+
+/**
+ * GET /environments/:env/apis 环境中已发布的API,按ID排序
+ */
+func EnvironmentApiList(ctx *fasthttp.RequestCtx) {
+	env, ok := envParam(ctx, "env", "")
+	if !ok {
+		return
+	}
+
+	list, err := apiList(env)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJson(ctx, fasthttp.StatusOK, readableApis(ctx, list))
+}
+
+/**
+ * GET /environments/:env/apis/:id 环境中已发布的API详情,ETag为该环境中的版本号
+ */
+func EnvironmentApiGet(ctx *fasthttp.RequestCtx) {
+	env, ok := envParam(ctx, "env", "")
+	if !ok {
+		return
+	}
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	api, revision, err := DAO.NewPublishedApiDao(env).GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if api == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("api %d is not published in %s", apiId, env))
+		return
+	}
+	if !authorize(ctx, auth.PERM_READ, api.GroupId) {
+		return
+	}
+	setETag(ctx, revision)
+	writeJson(ctx, fasthttp.StatusOK, api.Redacted())
+}
+
+/**
+ * DELETE /environments/:env/apis/:id 从环境中下线API并记录历史,草稿保留;
+ * 必须传入If-Match,仅当该环境中的版本号一致才下线
+ */
+func EnvironmentApiDelete(ctx *fasthttp.RequestCtx) {
+	env, ok := envParam(ctx, "env", "")
+	if !ok {
+		return
+	}
+	apiId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	expected, ok := ifMatch(ctx)
+	if !ok {
+		return
+	}
+
+	api, current, err := DAO.NewPublishedApiDao(env).GetApiWithRevision(apiId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if api == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("api %d is not published in %s", apiId, env))
+		return
+	}
+	if !authorize(ctx, auth.PERM_WRITE, api.GroupId) {
+		return
+	}
+	if expected != current {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d in %s has been modified, current revision is %d", apiId, env, current))
+		return
+	}
+
+	deleted, err := DAO.NewApiDao().UnpublishApi(api, expected, env, operator(ctx))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, fmt.Sprintf("api %d in %s has been modified concurrently", apiId, env))
+		return
+	}
+	audit(ctx, "api.unpublish", fmt.Sprintf("environments/%s/apis/%d", env, apiId), api.Redacted(), nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+/**
+ * 升级迁移: 引入发布流程之前的API没有State,由网关直接从API_加载;管理服务启动时把这些API
+ * 按发布的规则校验后发布到全部环境,记录历史和审计,避免升级后线上路由消失。
+ * 校验失败的API不发布,只输出日志,修正后需要手工发布;可以重复执行,返回迁移的API数量
+ */
+func MigrateLegacyApis() (int, error) {
+	apiDao := DAO.NewApiDao()
+	ids, err := apiDao.GetLegacyApiIds()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	services, err := serviceMap()
+	if err != nil {
+		return 0, err
+	}
+	groups, err := groupMap()
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, apiId := range ids {
+		legacy, revision, err := apiDao.GetLegacyApi(apiId)
+		if err != nil {
+			return migrated, err
+		}
+		if legacy == nil {
+			continue
+		}
+		if err := validateLegacyApi(legacy, services, groups); err != nil {
+			log.Printf("skip legacy api %d: %s", legacy.ApiId, err)
+			continue
+		}
+
+		published := legacy.Published(groups[legacy.GroupId])
+		committed, err := apiDao.MigrateLegacyApi(published, legacy, revision, systemOperator)
+		if err != nil {
+			return migrated, err
+		}
+		if committed == 0 {
+			//草稿已被修改或已发布,由修改者按正常流程发布
+			continue
+		}
+		systemAudit("api.migrate", fmt.Sprintf("apis/%d", legacy.ApiId), legacy.Redacted(), published.Redacted())
+		migrated++
+	}
+	return migrated, nil
+}
+
+/**
+ * 校验旧版本API能否发布到每个环境,与各环境已发布的API比较路由冲突
+ */
+func validateLegacyApi(api *model.Api, services map[int]*model.Service, groups map[int]*model.Group) error {
+	for _, env := range model.Environments {
+		existing, err := apiList(env)
+		if err != nil {
+			return err
+		}
+		if err := validator.ValidateApi(api, services, groups, existing); err != nil {
+			return fmt.Errorf("%s: %s", env, err)
+		}
+	}
+	return nil
+}
+
+/**
+ * 升级迁移: 引入分组快照之前发布的API没有快照,网关按分组的当前设置加载;管理服务启动时
+ * 为这些API写入所属分组当前设置的快照并记录历史和审计,之后修改分组需要重新发布才生效。
+ * 分组已不存在的API保持不变并输出日志;可以重复执行,返回写入快照的API数量
+ */
+func SnapshotPublishedGroups() (int, error) {
+	groups, err := groupMap()
+	if err != nil {
+		return 0, err
+	}
+
+	apiDao := DAO.NewApiDao()
+	snapshotted := 0
+	for _, env := range model.Environments {
+		list, err := apiList(env)
+		if err != nil {
+			return snapshotted, err
+		}
+		publishedDao := DAO.NewPublishedApiDao(env)
+		for _, api := range list {
+			if api.GroupId == 0 || api.Group != nil {
+				continue
+			}
+			group := groups[api.GroupId]
+			if group == nil {
+				log.Printf("skip api %d in %s: group %d not found", api.ApiId, env, api.GroupId)
+				continue
+			}
+			previous, revision, err := publishedDao.GetApiWithRevision(api.ApiId)
+			if err != nil {
+				return snapshotted, err
+			}
+			if previous == nil || previous.Group != nil {
+				continue
+			}
+
+			//以读取时的版本号原地重新发布,期间有其他发布则由其写入快照
+			published := previous.Published(group)
+			committed, err := apiDao.PromoteApi(published, previous, revision, env, systemOperator)
+			if err != nil {
+				return snapshotted, err
+			}
+			if committed == 0 {
+				continue
+			}
+			systemAudit("api.publish", fmt.Sprintf("environments/%s/apis/%d", env, api.ApiId), previous.Redacted(), published.Redacted())
+			snapshotted++
+		}
+	}
+	return snapshotted, nil
+}
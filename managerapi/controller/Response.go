@@ -0,0 +1,152 @@
+package controller
+
+import (
+	jsoniter "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+	"skyway/library"
+	"skyway/managerapi/auth"
+	"skyway/managerapi/model"
+	"skyway/managerapi/validator"
+	"strconv"
+	"strings"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+/**
+ * 输出JSON响应
+ */
+func writeJson(ctx *fasthttp.RequestCtx, statusCode int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType("application/json; charset=utf-8")
+	ctx.SetBody(data)
+}
+
+/**
+ * 输出错误响应
+ */
+func writeError(ctx *fasthttp.RequestCtx, statusCode int, message string) {
+	ServiceApi.NewDataResponse(ServiceApi.StatusCode(statusCode), message).Write(ctx, statusCode)
+}
+
+/**
+ * 输出校验结果,校验失败时输出400及字段错误并返回false
+ */
+func writeValidation(ctx *fasthttp.RequestCtx, err error) bool {
+	if err == nil {
+		return true
+	}
+	if result, ok := err.(*validator.ValidationError); ok {
+		response := ServiceApi.NewDataResponse(ServiceApi.CODE_VALIDATION_FAILED, result.Error())
+		response.Data = result.Errors
+		response.Write(ctx, fasthttp.StatusBadRequest)
+		return false
+	}
+	writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+	return false
+}
+
+/**
+ * 读取路由中的数字ID参数,非法时输出400
+ */
+func idParam(ctx *fasthttp.RequestCtx, name string) (int, bool) {
+	value, _ := ctx.UserValue(name).(string)
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, "invalid "+name+" '"+value+"'")
+		return 0, false
+	}
+	return id, true
+}
+
+/**
+ * 以etcd版本号作为ETag输出
+ */
+func setETag(ctx *fasthttp.RequestCtx, revision int64) {
+	ctx.Response.Header.Set("ETag", strconv.Quote(strconv.FormatInt(revision, 10)))
+}
+
+/**
+ * 读取If-Match中的版本号,未传或为*时输出428,避免不带版本号的请求覆盖他人的修改;
+ * 无法解析的ETag不可能匹配,输出412
+ */
+func ifMatch(ctx *fasthttp.RequestCtx) (int64, bool) {
+	value := strings.TrimSpace(string(ctx.Request.Header.Peek("If-Match")))
+	if value == "" || value == "*" {
+		writeError(ctx, fasthttp.StatusPreconditionRequired, "If-Match with the ETag of the current revision is required")
+		return 0, false
+	}
+	tag := strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	revision, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || revision <= 0 {
+		writeError(ctx, fasthttp.StatusPreconditionFailed, "If-Match "+value+" does not match")
+		return 0, false
+	}
+	return revision, true
+}
+
+/**
+ * 检查当前调用方在全部指定分组上拥有权限perm,否则输出403
+ */
+func authorize(ctx *fasthttp.RequestCtx, perm auth.Permission, groupIds ...int) bool {
+	principal := auth.FromContext(ctx)
+	for _, groupId := range groupIds {
+		if !principal.Allowed(perm, groupId) {
+			auth.Forbidden(ctx)
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * 过滤出当前调用方可读的API,认证密钥只保留指纹
+ */
+func readableApis(ctx *fasthttp.RequestCtx, apis []*model.Api) []*model.Api {
+	principal := auth.FromContext(ctx)
+	list := make([]*model.Api, 0, len(apis))
+	for _, api := range apis {
+		if principal.Allowed(auth.PERM_READ, api.GroupId) {
+			list = append(list, api.Redacted())
+		}
+	}
+	return list
+}
+
+/**
+ * 隐去认证密钥的API列表,用于输出和审计
+ */
+func redactedApis(apis []*model.Api) []*model.Api {
+	list := make([]*model.Api, 0, len(apis))
+	for _, api := range apis {
+		list = append(list, api.Redacted())
+	}
+	return list
+}
+
+/**
+ * 隐去认证密钥的分组列表,用于输出和审计
+ */
+func redactedGroups(groups []*model.Group) []*model.Group {
+	list := make([]*model.Group, 0, len(groups))
+	for _, group := range groups {
+		list = append(list, group.Redacted())
+	}
+	return list
+}
+
+/**
+ * 操作人,即认证通过的调用方,记录在API历史中
+ */
+func operator(ctx *fasthttp.RequestCtx) string {
+	principal := auth.FromContext(ctx)
+	if principal == nil {
+		return "anonymous"
+	}
+	return principal.Name
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"skyway/managerapi/auth"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"sort"
+)
+
+/**
+ * GET /roles 全部角色绑定,按调用方排序
+ */
+func RoleList(ctx *fasthttp.RequestCtx) {
+	bindings, err := DAO.NewRoleBindingDao().GetBindings()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	list := make([]*model.RoleBinding, 0, len(bindings))
+	for _, binding := range bindings {
+		list = append(list, binding)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Principal < list[j].Principal
+	})
+	writeJson(ctx, fasthttp.StatusOK, list)
+}
+
+/**
+ * GET /roles/:principal 调用方的角色绑定
+ */
+func RoleGet(ctx *fasthttp.RequestCtx) {
+	principal, _ := ctx.UserValue("principal").(string)
+	binding, err := DAO.NewRoleBindingDao().GetBinding(principal)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if binding == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("no roles bound to %s", principal))
+		return
+	}
+	writeJson(ctx, fasthttp.StatusOK, binding)
+}
+
+/**
+ * PUT /roles/:principal 设置调用方的角色,覆盖原有绑定;principal为user:用户名或cert:证书CommonName
+ */
+func RoleUpdate(ctx *fasthttp.RequestCtx) {
+	principal, _ := ctx.UserValue("principal").(string)
+	if _, _, ok := model.SplitPrincipal(principal); !ok {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid principal '%s', must be %s:<username> or %s:<common name>",
+			principal, model.PRINCIPAL_USER, model.PRINCIPAL_CERT))
+		return
+	}
+	binding := &model.RoleBinding{}
+	if err := json.Unmarshal(ctx.PostBody(), binding); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("invalid json: %s", err))
+		return
+	}
+	binding.Principal = principal
+
+	groups, err := groupMap()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	for i, grant := range binding.Grants {
+		if grant == nil || !auth.IsRole(grant.Role) {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("Grants[%d].Role must be one of %s, %s, %s",
+				i, model.ROLE_ADMIN, model.ROLE_GROUP_OWNER, model.ROLE_READ_ONLY))
+			return
+		}
+		if grant.GroupId != 0 && groups[grant.GroupId] == nil {
+			writeError(ctx, fasthttp.StatusBadRequest, fmt.Sprintf("Grants[%d].GroupId: group %d not found", i, grant.GroupId))
+			return
+		}
+	}
+
+	bindingDao := DAO.NewRoleBindingDao()
+	previous, err := bindingDao.GetBinding(principal)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if !bindingDao.RegisterBinding(binding) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save roles failed")
+		return
+	}
+	audit(ctx, "role.update", "roles/"+principal, previous, binding)
+	writeJson(ctx, fasthttp.StatusOK, binding)
+}
+
+/**
+ * DELETE /roles/:principal 删除调用方的全部角色
+ */
+func RoleDelete(ctx *fasthttp.RequestCtx) {
+	principal, _ := ctx.UserValue("principal").(string)
+	bindingDao := DAO.NewRoleBindingDao()
+	previous, err := bindingDao.GetBinding(principal)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	deleted, err := bindingDao.DelBinding(principal)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("no roles bound to %s", principal))
+		return
+	}
+	audit(ctx, "role.delete", "roles/"+principal, previous, nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
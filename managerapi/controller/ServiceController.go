@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"net"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"sort"
+)
+
+/**
+ * 解析并检查请求体中的服务定义
+ */
+func parseService(ctx *fasthttp.RequestCtx) (*model.Service, error) {
+	service := model.NewService()
+	if err := json.Unmarshal(ctx.PostBody(), service); err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+	if service.ServiceName == "" {
+		return nil, fmt.Errorf("ServiceName is required")
+	}
+	if len(service.Targets) == 0 {
+		return nil, fmt.Errorf("Targets is required")
+	}
+	for i, target := range service.Targets {
+		if target == nil {
+			return nil, fmt.Errorf("Targets[%d] is empty", i)
+		}
+		if _, _, err := net.SplitHostPort(target.Addr); err != nil {
+			return nil, fmt.Errorf("Targets[%d].Addr '%s' is not host:port", i, target.Addr)
+		}
+		if target.Weight < 0 {
+			return nil, fmt.Errorf("Targets[%d].Weight must not be negative", i)
+		}
+		if target.Weight == 0 {
+			target.Weight = 1
+		}
+	}
+	switch service.LbStrategy {
+	case "":
+		service.LbStrategy = model.LB_ROUND_ROBIN
+	case model.LB_ROUND_ROBIN, model.LB_WEIGHTED_ROUND_ROBIN, model.LB_LEAST_CONN, model.LB_RANDOM_TWO:
+	case model.LB_HASH:
+		switch service.HashOn {
+		case model.HASH_ON_IP:
+		case model.HASH_ON_HEADER, model.HASH_ON_COOKIE:
+			if service.HashKey == "" {
+				return nil, fmt.Errorf("HashKey is required when HashOn is %s", service.HashOn)
+			}
+		default:
+			return nil, fmt.Errorf("unknown HashOn '%s'", service.HashOn)
+		}
+	default:
+		return nil, fmt.Errorf("unknown LbStrategy '%s'", service.LbStrategy)
+	}
+	if check := service.HealthCheck; check != nil {
+		if check.Path != "" && check.Path[0] != '/' {
+			return nil, fmt.Errorf("HealthCheck.Path must begin with '/'")
+		}
+		if check.Interval < 0 || check.Timeout < 0 || check.HealthyThreshold < 0 || check.UnhealthyThreshold < 0 {
+			return nil, fmt.Errorf("HealthCheck settings must not be negative")
+		}
+	}
+	if outlier := service.OutlierDetection; outlier != nil {
+		if outlier.MaxFailures < 0 || outlier.EjectTime < 0 {
+			return nil, fmt.Errorf("OutlierDetection settings must not be negative")
+		}
+	}
+	if service.Timeouts.Negative() {
+		return nil, fmt.Errorf("timeouts must not be negative")
+	}
+	return service, nil
+}
+
+/**
+ * 全部服务,按ID索引
+ */
+func serviceMap() (map[int]*model.Service, error) {
+	services, err := DAO.NewServiceDao().GetServices()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]*model.Service, len(services))
+	for _, service := range services {
+		result[service.ServiceId] = service
+	}
+	return result, nil
+}
+
+/**
+ * POST /services 创建服务
+ */
+func ServiceCreate(ctx *fasthttp.RequestCtx) {
+	service, err := parseService(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	if service.ServiceId <= 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, "ServiceId is required")
+		return
+	}
+
+	serviceDao := DAO.NewServiceDao()
+	exist, err := serviceDao.GetService(service.ServiceId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist != nil {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("service %d already exists", service.ServiceId))
+		return
+	}
+	if !serviceDao.RegisterService(service) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save service failed")
+		return
+	}
+	audit(ctx, "service.create", fmt.Sprintf("services/%d", service.ServiceId), nil, service)
+	writeJson(ctx, fasthttp.StatusCreated, service)
+}
+
+/**
+ * GET /services 服务列表,按ID排序
+ */
+func ServiceList(ctx *fasthttp.RequestCtx) {
+	services, err := DAO.NewServiceDao().GetServices()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	list := make([]*model.Service, 0, len(services))
+	for _, service := range services {
+		list = append(list, service)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ServiceId < list[j].ServiceId
+	})
+	writeJson(ctx, fasthttp.StatusOK, list)
+}
+
+/**
+ * GET /services/:id 服务详情
+ */
+func ServiceGet(ctx *fasthttp.RequestCtx) {
+	serviceId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	service, err := DAO.NewServiceDao().GetService(serviceId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if service == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("service %d not found", serviceId))
+		return
+	}
+	writeJson(ctx, fasthttp.StatusOK, service)
+}
+
+/**
+ * PUT /services/:id 更新服务
+ */
+func ServiceUpdate(ctx *fasthttp.RequestCtx) {
+	serviceId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+	service, err := parseService(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	service.ServiceId = serviceId
+
+	serviceDao := DAO.NewServiceDao()
+	exist, err := serviceDao.GetService(serviceId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("service %d not found", serviceId))
+		return
+	}
+	if !serviceDao.RegisterService(service) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save service failed")
+		return
+	}
+	audit(ctx, "service.update", fmt.Sprintf("services/%d", serviceId), exist, service)
+	writeJson(ctx, fasthttp.StatusOK, service)
+}
+
+/**
+ * DELETE /services/:id 删除服务
+ */
+func ServiceDelete(ctx *fasthttp.RequestCtx) {
+	serviceId, ok := idParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	serviceDao := DAO.NewServiceDao()
+	exist, err := serviceDao.GetService(serviceId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	deleted, err := serviceDao.DelService(serviceId)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("service %d not found", serviceId))
+		return
+	}
+	audit(ctx, "service.delete", fmt.Sprintf("services/%d", serviceId), exist, nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
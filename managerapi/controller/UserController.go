@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/valyala/fasthttp"
+	"regexp"
+	"skyway/managerapi/auth"
+	"skyway/managerapi/dao"
+	"skyway/managerapi/model"
+	"sort"
+)
+
+//用户名作为etcd key的一部分,限制字符
+var usernameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.@-]{1,64}$`)
+
+/**
+ * 用户信息,不返回密码哈希
+ */
+type userView struct {
+	Username string
+}
+
+type userRequest struct {
+	Username string
+	Password string
+}
+
+/**
+ * 解析请求体并哈希密码
+ */
+func parseUser(ctx *fasthttp.RequestCtx) (*model.AdminUser, error) {
+	request := &userRequest{}
+	if err := json.Unmarshal(ctx.PostBody(), request); err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+	if len(request.Password) < 8 {
+		return nil, fmt.Errorf("Password must have at least 8 characters")
+	}
+	hash, err := auth.HashPassword(request.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &model.AdminUser{
+		Username:     request.Username,
+		PasswordHash: hash,
+	}, nil
+}
+
+/**
+ * POST /users 创建管理用户
+ */
+func UserCreate(ctx *fasthttp.RequestCtx) {
+	user, err := parseUser(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	if !usernameRegexp.MatchString(user.Username) {
+		writeError(ctx, fasthttp.StatusBadRequest, "Username must be 1-64 letters, digits or _.@-")
+		return
+	}
+
+	userDao := DAO.NewAdminUserDao()
+	exist, err := userDao.GetUser(user.Username)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist != nil {
+		writeError(ctx, fasthttp.StatusConflict, fmt.Sprintf("user %s already exists", user.Username))
+		return
+	}
+	if !userDao.RegisterUser(user) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save user failed")
+		return
+	}
+	audit(ctx, "user.create", "users/"+user.Username, nil, &userView{Username: user.Username})
+	writeJson(ctx, fasthttp.StatusCreated, &userView{Username: user.Username})
+}
+
+/**
+ * GET /users 用户列表,按用户名排序
+ */
+func UserList(ctx *fasthttp.RequestCtx) {
+	users, err := DAO.NewAdminUserDao().GetUsers()
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+
+	list := make([]*userView, 0, len(users))
+	for _, user := range users {
+		list = append(list, &userView{Username: user.Username})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+	writeJson(ctx, fasthttp.StatusOK, list)
+}
+
+/**
+ * PUT /users/:name 修改密码,全局admin可修改任意用户,其他用户只能修改自己的密码
+ */
+func UserUpdate(ctx *fasthttp.RequestCtx) {
+	username, _ := ctx.UserValue("name").(string)
+	principal := auth.FromContext(ctx)
+	self := principal != nil && principal.Method == auth.METHOD_BASIC && principal.Name == username
+	if !self && !authorize(ctx, auth.PERM_ADMIN, 0) {
+		return
+	}
+	user, err := parseUser(ctx)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err.Error())
+		return
+	}
+	user.Username = username
+
+	userDao := DAO.NewAdminUserDao()
+	exist, err := userDao.GetUser(username)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if exist == nil {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("user %s not found", username))
+		return
+	}
+	if !userDao.RegisterUser(user) {
+		writeError(ctx, fasthttp.StatusInternalServerError, "save user failed")
+		return
+	}
+	audit(ctx, "user.update", "users/"+username, &userView{Username: username}, &userView{Username: username})
+	writeJson(ctx, fasthttp.StatusOK, &userView{Username: user.Username})
+}
+
+/**
+ * DELETE /users/:name 删除用户
+ */
+func UserDelete(ctx *fasthttp.RequestCtx) {
+	username, _ := ctx.UserValue("name").(string)
+	deleted, err := DAO.NewAdminUserDao().DelUser(username)
+	if err != nil {
+		writeError(ctx, fasthttp.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		writeError(ctx, fasthttp.StatusNotFound, fmt.Sprintf("user %s not found", username))
+		return
+	}
+	audit(ctx, "user.delete", "users/"+username, &userView{Username: username}, nil)
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
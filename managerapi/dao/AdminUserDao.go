@@ -0,0 +1,77 @@
+package DAO
+
+import (
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+)
+
+type AdminUserDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewAdminUserDao() *AdminUserDAO {
+	return &AdminUserDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	ADMIN_USER_PREFIX = "ADMIN_USER_"
+)
+
+func getAdminUserKey(username string) string {
+	return ADMIN_USER_PREFIX + username
+}
+
+/**
+ * 注册或更新用户
+ */
+func (userDao *AdminUserDAO) RegisterUser(user *model.AdminUser) bool {
+	data, err := json.Marshal(user)
+	if err == nil {
+		return userDao.client.Put(getAdminUserKey(user.Username), string(data))
+	}
+	return false
+}
+
+/**
+ * 获取指定用户,不存在时返回nil
+ */
+func (userDao *AdminUserDAO) GetUser(username string) (*model.AdminUser, error) {
+	value, err := userDao.client.Get(getAdminUserKey(username))
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	user := &model.AdminUser{}
+	if err := json.UnmarshalFromString(value, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+/**
+ * 获取全部用户
+ */
+func (userDao *AdminUserDAO) GetUsers() (map[string]*model.AdminUser, error) {
+	values, err := userDao.client.GetAll(ADMIN_USER_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]*model.AdminUser)
+	for k, v := range values {
+		user := &model.AdminUser{}
+		if json.UnmarshalFromString(v, user) == nil {
+			users[k] = user
+		}
+	}
+	return users, nil
+}
+
+/**
+ * 删除指定用户
+ */
+func (userDao *AdminUserDAO) DelUser(username string) (int64, error) {
+	return userDao.client.Delete(getAdminUserKey(username))
+}
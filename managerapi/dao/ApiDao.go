@@ -2,9 +2,11 @@ package DAO
 
 import (
 	"fmt"
+	"github.com/coreos/etcd/clientv3"
 	jsoniter "github.com/json-iterator/go"
 	"skyway/library/DataSource"
 	"skyway/managerapi/model"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -25,6 +27,8 @@ func NewApiDao() *ApiDAO {
 const (
 	API_PREFIX     = "API_"
 	API_KEY_FORMAT = "API_%d"
+	//API ID计数器,不能以API_开头,否则会被前缀查询和监听取到
+	API_ID_SEQUENCE = "SEQ_API"
 )
 
 func getApiKey(apiId int) string {
@@ -47,6 +51,261 @@ func (apiDao *ApiDAO) RegisterApi(api *model.Api) bool {
 	return false
 }
 
+/**
+ * 创建API并记录历史,返回版本号;ID已存在时返回0
+ */
+func (apiDao *ApiDAO) CreateApi(api *model.Api, operator string) (int64, error) {
+	return apiDao.saveApi(api, 0, &model.ApiVersion{
+		Action:   model.API_ACTION_CREATE,
+		Operator: operator,
+	})
+}
+
+/**
+ * 仅当API的当前版本号等于revision时更新并记录历史,previous为更新前的记录;
+ * 返回新的版本号,版本号不一致时返回0
+ */
+func (apiDao *ApiDAO) UpdateApi(api *model.Api, previous *model.Api, revision int64, operator string) (int64, error) {
+	return apiDao.saveApi(api, revision, &model.ApiVersion{
+		Action:   model.API_ACTION_UPDATE,
+		Operator: operator,
+		Previous: previous,
+	})
+}
+
+/**
+ * 回滚API到历史版本rollbackTo的内容,API已删除时previous为nil,revision为0
+ */
+func (apiDao *ApiDAO) RollbackApi(api *model.Api, previous *model.Api, revision int64, rollbackTo int64, operator string) (int64, error) {
+	return apiDao.saveApi(api, revision, &model.ApiVersion{
+		Action:     model.API_ACTION_ROLLBACK,
+		RollbackTo: rollbackTo,
+		Operator:   operator,
+		Previous:   previous,
+	})
+}
+
+/**
+ * 发布草稿到环境env并记录历史,published为带分组快照的发布副本,草稿状态同时改为published;
+ * 仅当草稿的当前版本号等于revision,且所属分组的版本号等于groupRevision时提交,
+ * 保证快照就是发布时的分组设置;返回草稿新的版本号,版本号不一致时返回0
+ */
+func (apiDao *ApiDAO) PublishApi(published *model.Api, draft *model.Api, revision int64, groupRevision int64, env string, operator string) (int64, error) {
+	data, err := json.Marshal(published)
+	if err != nil {
+		return 0, err
+	}
+	draftData, err := json.Marshal(draftOf(published))
+	if err != nil {
+		return 0, err
+	}
+	change := &model.ApiVersion{
+		ApiId:       draft.ApiId,
+		Action:      model.API_ACTION_PUBLISH,
+		Environment: env,
+		Operator:    operator,
+		Previous:    draft,
+		Current:     published,
+	}
+
+	apiKey := getApiKey(draft.ApiId)
+	revisions := map[string]int64{apiKey: revision}
+	if draft.GroupId != 0 {
+		revisions[getGroupKey(draft.GroupId)] = groupRevision
+	}
+	return apiDao.commitAll(revisions, change,
+		clientv3.OpPut(apiKey, string(draftData)),
+		clientv3.OpPut(getPublishedApiKey(env, draft.ApiId), string(data)))
+}
+
+/**
+ * 发布副本对应的草稿记录,草稿不保存分组快照
+ */
+func draftOf(published *model.Api) *model.Api {
+	draft := *published
+	draft.Group = nil
+	return &draft
+}
+
+/**
+ * 将已发布的API连同分组快照晋级到环境env并记录历史,previous为env中原来发布的版本;
+ * 仅当env中已发布API的版本号等于revision时提交,revision为0表示env中尚未发布,版本号不一致时返回0
+ */
+func (apiDao *ApiDAO) PromoteApi(api *model.Api, previous *model.Api, revision int64, env string, operator string) (int64, error) {
+	data, err := json.Marshal(api)
+	if err != nil {
+		return 0, err
+	}
+	change := &model.ApiVersion{
+		ApiId:       api.ApiId,
+		Action:      model.API_ACTION_PUBLISH,
+		Environment: env,
+		Operator:    operator,
+		Previous:    previous,
+		Current:     api,
+	}
+
+	publishedKey := getPublishedApiKey(env, api.ApiId)
+	return apiDao.commit(publishedKey, revision, change, clientv3.OpPut(publishedKey, string(data)))
+}
+
+/**
+ * 从环境env下线API并记录历史,previous为下线前发布的版本;
+ * 仅当env中已发布API的版本号等于revision时删除,返回是否删除
+ */
+func (apiDao *ApiDAO) UnpublishApi(previous *model.Api, revision int64, env string, operator string) (bool, error) {
+	change := &model.ApiVersion{
+		ApiId:       previous.ApiId,
+		Action:      model.API_ACTION_UNPUBLISH,
+		Environment: env,
+		Operator:    operator,
+		Previous:    previous,
+	}
+
+	publishedKey := getPublishedApiKey(env, previous.ApiId)
+	committed, err := apiDao.commit(publishedKey, revision, change, clientv3.OpDelete(publishedKey))
+	return committed > 0, err
+}
+
+/**
+ * 升级迁移: 引入发布流程之前的API记录没有State字段,返回这些API的ID
+ */
+func (apiDao *ApiDAO) GetLegacyApiIds() ([]int, error) {
+	values, err := apiDao.client.GetAll(API_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0)
+	for key, value := range values {
+		if !isLegacyApi(value) {
+			continue
+		}
+		if apiId, err := strconv.Atoi(strings.TrimPrefix(key, API_PREFIX)); err == nil {
+			ids = append(ids, apiId)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+/**
+ * 获取没有State字段的旧版本API及其版本号,不存在或已有State时返回nil
+ */
+func (apiDao *ApiDAO) GetLegacyApi(apiId int) (*model.Api, int64, error) {
+	value, revision, err := apiDao.client.GetWithRevision(getApiKey(apiId))
+	if err != nil || !isLegacyApi(value) {
+		return nil, 0, err
+	}
+
+	api := model.NewApi()
+	if err := json.UnmarshalFromString(value, api); err != nil {
+		return nil, 0, err
+	}
+	//NewApi的默认状态是draft,旧记录保持未设置
+	api.State = ""
+	return api, revision, nil
+}
+
+/**
+ * 记录的JSON中没有State字段;NewApi带有默认状态,只能按原始JSON判断
+ */
+func isLegacyApi(value string) bool {
+	var legacy struct{ State *string }
+	return value != "" && json.UnmarshalFromString(value, &legacy) == nil && legacy.State == nil
+}
+
+/**
+ * 升级迁移: 把没有State的旧版本API发布到全部环境,api为带分组快照的发布副本,
+ * 草稿状态同时改为published并记录历史,previous为迁移前的记录;
+ * 仅当草稿版本号等于revision且各环境尚未发布时提交,返回新的版本号,条件不满足时返回0
+ */
+func (apiDao *ApiDAO) MigrateLegacyApi(api *model.Api, previous *model.Api, revision int64, operator string) (int64, error) {
+	data, err := json.Marshal(api)
+	if err != nil {
+		return 0, err
+	}
+	change := &model.ApiVersion{
+		ApiId:    api.ApiId,
+		Action:   model.API_ACTION_MIGRATE,
+		Operator: operator,
+		Previous: previous,
+		Current:  api,
+	}
+
+	draftData, err := json.Marshal(draftOf(api))
+	if err != nil {
+		return 0, err
+	}
+
+	apiKey := getApiKey(api.ApiId)
+	revisions := map[string]int64{apiKey: revision}
+	ops := []clientv3.Op{clientv3.OpPut(apiKey, string(draftData))}
+	for _, env := range model.Environments {
+		publishedKey := getPublishedApiKey(env, api.ApiId)
+		revisions[publishedKey] = 0
+		ops = append(ops, clientv3.OpPut(publishedKey, string(data)))
+	}
+	return apiDao.commitAll(revisions, change, ops...)
+}
+
+func (apiDao *ApiDAO) saveApi(api *model.Api, revision int64, change *model.ApiVersion) (int64, error) {
+	data, err := json.Marshal(api)
+	if err != nil {
+		return 0, err
+	}
+	change.ApiId = api.ApiId
+	change.Current = api
+
+	apiKey := getApiKey(api.ApiId)
+	return apiDao.commit(apiKey, revision, change, clientv3.OpPut(apiKey, string(data)))
+}
+
+/**
+ * 仅当key的版本号等于revision且历史版本号未被占用时,在同一事务中执行ops并写入历史;
+ * 草稿和各环境的写入以不同的key为条件,靠历史key保证并发变更不会写入相同的历史版本号
+ */
+func (apiDao *ApiDAO) commit(key string, revision int64, change *model.ApiVersion, ops ...clientv3.Op) (int64, error) {
+	return apiDao.commitAll(map[string]int64{key: revision}, change, ops...)
+}
+
+/**
+ * 同commit,以revisions中全部key的版本号为条件
+ */
+func (apiDao *ApiDAO) commitAll(revisions map[string]int64, change *model.ApiVersion, ops ...clientv3.Op) (int64, error) {
+	history, err := NewApiHistoryDao().record(change)
+	if err != nil {
+		return 0, err
+	}
+	revisions[getApiHistoryKey(change.ApiId, change.Version)] = 0
+	return apiDao.client.CompareAllAndCommit(revisions, append(ops, history)...)
+}
+
+/**
+ * 获取指定API,不存在时返回nil
+ */
+func (apiDao *ApiDAO) GetApi(apiId int) (*model.Api, error) {
+	api, _, err := apiDao.GetApiWithRevision(apiId)
+	return api, err
+}
+
+/**
+ * 获取指定API及其版本号(etcd的ModRevision),不存在时返回nil
+ */
+func (apiDao *ApiDAO) GetApiWithRevision(apiId int) (*model.Api, int64, error) {
+	value, revision, err := apiDao.client.GetWithRevision(getApiKey(apiId))
+	if err != nil || value == "" {
+		return nil, 0, err
+	}
+
+	api := model.NewApi()
+	err = json.UnmarshalFromString(value, api)
+	if err != nil {
+		return nil, 0, err
+	}
+	return api, revision, nil
+}
+
 /**
  * 获取全部API列表
  */
@@ -78,6 +337,22 @@ func (apiDao *ApiDAO) DelApi(apiId int) (int64, error) {
 	return effect, err
 }
 
+/**
+ * 仅当API的当前版本号等于revision时删除并记录历史,previous为删除前的记录,返回是否删除
+ */
+func (apiDao *ApiDAO) DelApiIfRevision(previous *model.Api, revision int64, operator string) (bool, error) {
+	change := &model.ApiVersion{
+		ApiId:    previous.ApiId,
+		Action:   model.API_ACTION_DELETE,
+		Operator: operator,
+		Previous: previous,
+	}
+
+	apiKey := getApiKey(previous.ApiId)
+	committed, err := apiDao.commit(apiKey, revision, change, clientv3.OpDelete(apiKey))
+	return committed > 0, err
+}
+
 /**
  * 删除所有API
  */
@@ -86,12 +361,58 @@ func (apiDao *ApiDAO) DelAllApis() (int64, error) {
 	return effect, err
 }
 
+/**
+ * 获取已有API的最大ID,按数字比较,没有API时返回0
+ */
 func (apiDao *ApiDAO) GetMaxId() (int, error) {
-	maxKey, err := apiDao.client.GetMaxKey(API_PREFIX)
+	apis, err := apiDao.client.GetAll(API_PREFIX)
 	if err != nil {
 		return -1, err
 	}
 
-	idstr := strings.Replace(maxKey, API_PREFIX, "", 1)
-	return strconv.Atoi(idstr)
+	maxId := 0
+	for key := range apis {
+		apiId, err := strconv.Atoi(strings.TrimPrefix(key, API_PREFIX))
+		if err == nil && apiId > maxId {
+			maxId = apiId
+		}
+	}
+	return maxId, nil
+}
+
+/**
+ * 分配新的API ID,通过计数器的CAS保证并发创建时不重复;
+ * 计数器落后于已有最大ID时(如手工指定了ID)从最大ID继续
+ */
+func (apiDao *ApiDAO) NextApiId() (int, error) {
+	for {
+		value, revision, err := apiDao.client.GetWithRevision(API_ID_SEQUENCE)
+		if err != nil {
+			return -1, err
+		}
+		current := 0
+		if value != "" {
+			current, err = strconv.Atoi(value)
+			if err != nil {
+				return -1, fmt.Errorf("invalid api id sequence %q", value)
+			}
+		}
+
+		maxId, err := apiDao.GetMaxId()
+		if err != nil {
+			return -1, err
+		}
+		if maxId > current {
+			current = maxId
+		}
+
+		nextId := current + 1
+		swapped, err := apiDao.client.CompareAndSwap(API_ID_SEQUENCE, revision, strconv.Itoa(nextId))
+		if err != nil {
+			return -1, err
+		}
+		if swapped > 0 {
+			return nextId, nil
+		}
+	}
 }
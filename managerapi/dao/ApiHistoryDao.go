@@ -0,0 +1,154 @@
+package DAO
+
+import (
+	"fmt"
+	"github.com/coreos/etcd/clientv3"
+	"reflect"
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * API历史版本,只追加不修改
+ */
+type ApiHistoryDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewApiHistoryDao() *ApiHistoryDAO {
+	return &ApiHistoryDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	//不能以API_开头,否则会被API的前缀查询和网关的监听取到
+	API_HISTORY_PREFIX = "HISTORY_API_"
+	//版本号补零,按key排序即按版本排序
+	API_HISTORY_KEY_FORMAT = "HISTORY_API_%d_%010d"
+)
+
+func getApiHistoryPrefix(apiId int) string {
+	return fmt.Sprintf("%s%d_", API_HISTORY_PREFIX, apiId)
+}
+
+func getApiHistoryKey(apiId int, version int64) string {
+	return fmt.Sprintf(API_HISTORY_KEY_FORMAT, apiId, version)
+}
+
+/**
+ * 获取API的全部历史版本,按版本号升序
+ */
+func (historyDao *ApiHistoryDAO) GetVersions(apiId int) ([]*model.ApiVersion, error) {
+	values, err := historyDao.client.GetAll(getApiHistoryPrefix(apiId))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*model.ApiVersion, 0, len(values))
+	for _, value := range values {
+		version := &model.ApiVersion{}
+		if err := json.UnmarshalFromString(value, version); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+/**
+ * 获取API的指定版本,不存在时返回nil
+ */
+func (historyDao *ApiHistoryDAO) GetVersion(apiId int, version int64) (*model.ApiVersion, error) {
+	value, err := historyDao.client.Get(getApiHistoryKey(apiId, version))
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	apiVersion := &model.ApiVersion{}
+	if err := json.UnmarshalFromString(value, apiVersion); err != nil {
+		return nil, err
+	}
+	return apiVersion, nil
+}
+
+/**
+ * 最新的版本号,没有历史时返回0
+ */
+func (historyDao *ApiHistoryDAO) latestVersion(apiId int) (int64, error) {
+	prefix := getApiHistoryPrefix(apiId)
+	maxKey, err := historyDao.client.GetMaxKey(prefix)
+	if err != nil || maxKey == "" {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimPrefix(maxKey, prefix), 10, 64)
+}
+
+/**
+ * 生成一条历史版本的写入操作,与API的写入放在同一事务中提交;
+ * 事务以该历史版本key不存在为条件,同一API的并发变更只有一个成功,版本号不会重复
+ */
+func (historyDao *ApiHistoryDAO) record(change *model.ApiVersion) (clientv3.Op, error) {
+	latest, err := historyDao.latestVersion(change.ApiId)
+	if err != nil {
+		return clientv3.Op{}, err
+	}
+	change.Version = latest + 1
+	change.Time = time.Now()
+	change.Changes = diffApi(change.Previous, change.Current)
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return clientv3.Op{}, err
+	}
+	return clientv3.OpPut(getApiHistoryKey(change.ApiId, change.Version), string(data)), nil
+}
+
+/**
+ * 按JSON字段比较两条API记录,nil视为所有字段为空
+ */
+func diffApi(before *model.Api, after *model.Api) []*model.FieldChange {
+	beforeFields := apiFields(before)
+	afterFields := apiFields(after)
+
+	names := make([]string, 0, len(beforeFields)+len(afterFields))
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]*model.FieldChange, 0)
+	for _, name := range names {
+		if !reflect.DeepEqual(beforeFields[name], afterFields[name]) {
+			changes = append(changes, &model.FieldChange{
+				Field:  name,
+				Before: beforeFields[name],
+				After:  afterFields[name],
+			})
+		}
+	}
+	return changes
+}
+
+func apiFields(api *model.Api) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if api == nil {
+		return fields
+	}
+	data, err := json.Marshal(api)
+	if err == nil {
+		_ = json.Unmarshal(data, &fields)
+	}
+	return fields
+}
@@ -0,0 +1,129 @@
+package DAO
+
+import (
+	"fmt"
+	"math/rand"
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+	"strings"
+	"time"
+)
+
+type AuditDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewAuditDao() *AuditDAO {
+	return &AuditDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	AUDIT_PREFIX = "AUDIT_"
+	//纳秒时间戳补零,按key排序即按时间排序,可按时间范围查询;随机后缀避免同一时刻的记录冲突
+	AUDIT_KEY_FORMAT = "AUDIT_%020d_%08x"
+	//过滤查询时每次从etcd读取的最少条数
+	auditPageSize = 100
+)
+
+func getAuditKey(t time.Time) string {
+	return fmt.Sprintf(AUDIT_KEY_FORMAT, t.UnixNano(), rand.Uint32())
+}
+
+/**
+ * 时间t的记录key下界,早于t的记录key都小于它
+ */
+func getAuditTimeKey(t time.Time) string {
+	return fmt.Sprintf("%s%020d", AUDIT_PREFIX, t.UnixNano())
+}
+
+/**
+ * 写入审计记录
+ */
+func (auditDao *AuditDAO) AddRecord(record *model.AuditRecord) error {
+	key := getAuditKey(record.Time)
+	record.Id = key[len(AUDIT_PREFIX):]
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if !auditDao.client.Put(key, string(data)) {
+		return fmt.Errorf("save audit record %s failed", record.Id)
+	}
+	return nil
+}
+
+/**
+ * 获取时间范围[from,to)内满足match的最多limit条审计记录,按时间倒序;
+ * 由etcd按key倒序分页返回,取够limit条即停止,不读取整个时间范围
+ */
+func (auditDao *AuditDAO) GetRecords(from time.Time, to time.Time, limit int, match func(record *model.AuditRecord) bool) ([]*model.AuditRecord, error) {
+	pageSize := limit
+	if pageSize < auditPageSize {
+		pageSize = auditPageSize
+	}
+	startKey := getAuditTimeKey(from)
+	endKey := getAuditTimeKey(to)
+
+	records := make([]*model.AuditRecord, 0)
+	for len(records) < limit {
+		kvs, err := auditDao.client.GetRangeDescend(startKey, endKey, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			record := &model.AuditRecord{}
+			if json.Unmarshal(kv.Value, record) == nil && match(record) {
+				records = append(records, record)
+				if len(records) == limit {
+					break
+				}
+			}
+		}
+		if len(kvs) < pageSize {
+			break
+		}
+		//下一页从本页最小的key之前继续
+		endKey = string(kvs[len(kvs)-1].Key)
+	}
+	return records, nil
+}
+
+/**
+ * 删除早于before的审计记录,返回删除的条数
+ */
+func (auditDao *AuditDAO) DelRecordsBefore(before time.Time) (int64, error) {
+	return auditDao.client.DeleteRange(AUDIT_PREFIX, getAuditTimeKey(before))
+}
+
+/**
+ * 改写操作以actionPrefix开头的审计记录,rewrite返回true时保存修改后的记录;
+ * 只用于脱敏等升级迁移,返回修改的记录数
+ */
+func (auditDao *AuditDAO) RewriteRecords(actionPrefix string, rewrite func(record *model.AuditRecord) bool) (int, error) {
+	values, err := auditDao.client.GetAll(AUDIT_PREFIX)
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for key, value := range values {
+		record := &model.AuditRecord{}
+		if json.UnmarshalFromString(value, record) != nil || !strings.HasPrefix(record.Action, actionPrefix) {
+			continue
+		}
+		if !rewrite(record) {
+			continue
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return rewritten, err
+		}
+		if !auditDao.client.Put(key, string(data)) {
+			return rewritten, fmt.Errorf("save audit record %s failed", record.Id)
+		}
+		rewritten++
+	}
+	return rewritten, nil
+}
@@ -0,0 +1,77 @@
+package DAO
+
+import (
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+)
+
+type ConsumerDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewConsumerDao() *ConsumerDAO {
+	return &ConsumerDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	CONSUMER_PREFIX = "CONSUMER_"
+)
+
+func getConsumerKey(name string) string {
+	return CONSUMER_PREFIX + name
+}
+
+/**
+ * 注册或更新调用方
+ */
+func (consumerDao *ConsumerDAO) RegisterConsumer(consumer *model.Consumer) bool {
+	data, err := json.Marshal(consumer)
+	if err == nil {
+		return consumerDao.client.Put(getConsumerKey(consumer.ConsumerName), string(data))
+	}
+	return false
+}
+
+/**
+ * 获取指定调用方,不存在时返回nil
+ */
+func (consumerDao *ConsumerDAO) GetConsumer(name string) (*model.Consumer, error) {
+	value, err := consumerDao.client.Get(getConsumerKey(name))
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	consumer := model.NewConsumer()
+	if err := json.UnmarshalFromString(value, consumer); err != nil {
+		return nil, err
+	}
+	return consumer, nil
+}
+
+/**
+ * 获取全部调用方
+ */
+func (consumerDao *ConsumerDAO) GetConsumers() (map[string]*model.Consumer, error) {
+	values, err := consumerDao.client.GetAll(CONSUMER_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	consumers := make(map[string]*model.Consumer)
+	for k, v := range values {
+		consumer := model.NewConsumer()
+		if json.UnmarshalFromString(v, consumer) == nil {
+			consumers[k] = consumer
+		}
+	}
+	return consumers, nil
+}
+
+/**
+ * 删除指定调用方
+ */
+func (consumerDao *ConsumerDAO) DelConsumer(name string) (int64, error) {
+	return consumerDao.client.Delete(getConsumerKey(name))
+}
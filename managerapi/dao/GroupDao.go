@@ -0,0 +1,126 @@
+package DAO
+
+import (
+	"fmt"
+	"github.com/coreos/etcd/clientv3"
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+)
+
+type GroupDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewGroupDao() *GroupDAO {
+	return &GroupDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	GROUP_PREFIX     = "GROUP_"
+	GROUP_KEY_FORMAT = "GROUP_%d"
+)
+
+func getGroupKey(groupId int) string {
+	return fmt.Sprintf(GROUP_KEY_FORMAT, groupId)
+}
+
+/**
+ * 创建分组,返回版本号;ID已存在时返回0
+ */
+func (groupDao *GroupDAO) CreateGroup(group *model.Group) (int64, error) {
+	return groupDao.saveGroup(group, 0)
+}
+
+/**
+ * 仅当分组的当前版本号等于revision时更新,返回新的版本号,版本号不一致时返回0
+ */
+func (groupDao *GroupDAO) UpdateGroup(group *model.Group, revision int64) (int64, error) {
+	return groupDao.saveGroup(group, revision)
+}
+
+func (groupDao *GroupDAO) saveGroup(group *model.Group, revision int64) (int64, error) {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return 0, err
+	}
+	return groupDao.client.CompareAndSwap(getGroupKey(group.GroupId), revision, string(data))
+}
+
+/**
+ * 获取指定分组,不存在时返回nil
+ */
+func (groupDao *GroupDAO) GetGroup(groupId int) (*model.Group, error) {
+	group, _, err := groupDao.GetGroupWithRevision(groupId)
+	return group, err
+}
+
+/**
+ * 获取指定分组及其版本号(etcd的ModRevision),不存在时返回nil
+ */
+func (groupDao *GroupDAO) GetGroupWithRevision(groupId int) (*model.Group, int64, error) {
+	value, revision, err := groupDao.client.GetWithRevision(getGroupKey(groupId))
+	if err != nil || value == "" {
+		return nil, 0, err
+	}
+
+	group := model.NewGroup()
+	if err := json.UnmarshalFromString(value, group); err != nil {
+		return nil, 0, err
+	}
+	return group, revision, nil
+}
+
+/**
+ * 获取全部分组列表
+ */
+func (groupDao *GroupDAO) GetGroups() (map[string]*model.Group, error) {
+	groups, err := groupDao.client.GetAll(GROUP_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	groupModels := make(map[string]*model.Group)
+	for k, v := range groups {
+		group := model.NewGroup()
+		if json.UnmarshalFromString(v, group) == nil {
+			groupModels[k] = group
+		}
+	}
+	return groupModels, nil
+}
+
+/**
+ * 仅当分组的当前版本号等于revision且没有草稿或已发布的API属于该分组时删除;
+ * 读取API之后有API新增或修改时不删除,避免期间加入分组的API失去分组后被网关丢弃。
+ * 返回是否删除,分组仍被使用时返回使用该分组的API ID
+ */
+func (groupDao *GroupDAO) DelGroupIfUnused(groupId int, revision int64) (bool, int, error) {
+	prefixes := []string{API_PREFIX}
+	for _, env := range model.Environments {
+		prefixes = append(prefixes, PublishedApiPrefix(env))
+	}
+
+	//以第一次读取时的版本号为准,之后的修改都会使事务失败
+	var since int64
+	for _, prefix := range prefixes {
+		values, read, err := groupDao.client.GetAllWithRevision(prefix)
+		if err != nil {
+			return false, 0, err
+		}
+		if since == 0 {
+			since = read
+		}
+		for _, value := range values {
+			api := model.NewApi()
+			if json.UnmarshalFromString(value, api) == nil && api.GroupId == groupId {
+				return false, api.ApiId, nil
+			}
+		}
+	}
+
+	groupKey := getGroupKey(groupId)
+	committed, err := groupDao.client.CompareUnchangedAndCommit(groupKey, revision, prefixes, since, clientv3.OpDelete(groupKey))
+	return committed > 0, 0, err
+}
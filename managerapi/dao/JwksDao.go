@@ -0,0 +1,61 @@
+package DAO
+
+import (
+	"skyway/library/DataSource"
+	"strings"
+)
+
+type JwksDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewJwksDao() *JwksDAO {
+	return &JwksDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	JWKS_PREFIX = "JWKS_"
+)
+
+func getJwksKey(name string) string {
+	return JWKS_PREFIX + name
+}
+
+/**
+ * 保存JWKS原文
+ */
+func (jwksDao *JwksDAO) RegisterJwks(name string, jwks string) bool {
+	return jwksDao.client.Put(getJwksKey(name), jwks)
+}
+
+/**
+ * 获取指定JWKS原文,不存在时返回空字符串
+ */
+func (jwksDao *JwksDAO) GetJwks(name string) (string, error) {
+	return jwksDao.client.Get(getJwksKey(name))
+}
+
+/**
+ * 获取全部JWKS原文,按名称索引
+ */
+func (jwksDao *JwksDAO) GetAllJwks() (map[string]string, error) {
+	values, err := jwksDao.client.GetAll(JWKS_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]string, len(values))
+	for k, v := range values {
+		all[strings.TrimPrefix(k, JWKS_PREFIX)] = v
+	}
+	return all, nil
+}
+
+/**
+ * 删除指定JWKS
+ */
+func (jwksDao *JwksDAO) DelJwks(name string) (int64, error) {
+	return jwksDao.client.Delete(getJwksKey(name))
+}
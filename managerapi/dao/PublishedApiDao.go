@@ -0,0 +1,82 @@
+package DAO
+
+import (
+	"fmt"
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+)
+
+/**
+ * 已发布到指定环境的API,网关按所服务的环境加载
+ */
+type PublishedApiDAO struct {
+	client      *DataSource.EtcdClient
+	Environment string
+}
+
+func NewPublishedApiDao(env string) *PublishedApiDAO {
+	return &PublishedApiDAO{
+		client:      DataSource.GetInstance(),
+		Environment: env,
+	}
+}
+
+const (
+	//不能以API_开头,否则会被草稿的前缀查询取到
+	PUBLISHED_PREFIX_FORMAT = "PUBLISHED_%s_API_"
+	PUBLISHED_KEY_FORMAT    = "PUBLISHED_%s_API_%d"
+)
+
+/**
+ * 指定环境已发布API的key前缀
+ */
+func PublishedApiPrefix(env string) string {
+	return fmt.Sprintf(PUBLISHED_PREFIX_FORMAT, env)
+}
+
+func getPublishedApiKey(env string, apiId int) string {
+	return fmt.Sprintf(PUBLISHED_KEY_FORMAT, env, apiId)
+}
+
+/**
+ * 获取当前环境中已发布的API,不存在时返回nil
+ */
+func (publishedDao *PublishedApiDAO) GetApi(apiId int) (*model.Api, error) {
+	api, _, err := publishedDao.GetApiWithRevision(apiId)
+	return api, err
+}
+
+/**
+ * 获取当前环境中已发布的API及其版本号,不存在时返回nil
+ */
+func (publishedDao *PublishedApiDAO) GetApiWithRevision(apiId int) (*model.Api, int64, error) {
+	value, revision, err := publishedDao.client.GetWithRevision(getPublishedApiKey(publishedDao.Environment, apiId))
+	if err != nil || value == "" {
+		return nil, 0, err
+	}
+
+	api := model.NewApi()
+	if err := json.UnmarshalFromString(value, api); err != nil {
+		return nil, 0, err
+	}
+	return api, revision, nil
+}
+
+/**
+ * 获取当前环境中已发布的全部API
+ */
+func (publishedDao *PublishedApiDAO) GetApis() (map[string]*model.Api, error) {
+	values, err := publishedDao.client.GetAll(PublishedApiPrefix(publishedDao.Environment))
+	if err != nil {
+		return nil, err
+	}
+
+	apis := make(map[string]*model.Api)
+	for k, v := range values {
+		api := model.NewApi()
+		if err := json.UnmarshalFromString(v, api); err == nil {
+			apis[k] = api
+		}
+	}
+	return apis, nil
+}
@@ -0,0 +1,133 @@
+package DAO
+
+import (
+	"github.com/coreos/etcd/clientv3"
+	"log"
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+)
+
+type RoleBindingDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewRoleBindingDao() *RoleBindingDAO {
+	return &RoleBindingDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	ROLE_BINDING_PREFIX = "ROLE_BINDING_"
+)
+
+func getRoleBindingKey(principal string) string {
+	return ROLE_BINDING_PREFIX + principal
+}
+
+/**
+ * 保存调用方的角色绑定,覆盖原有绑定
+ */
+func (bindingDao *RoleBindingDAO) RegisterBinding(binding *model.RoleBinding) bool {
+	data, err := json.Marshal(binding)
+	if err == nil {
+		return bindingDao.client.Put(getRoleBindingKey(binding.Principal), string(data))
+	}
+	return false
+}
+
+/**
+ * 获取调用方的角色绑定,不存在时返回nil
+ */
+func (bindingDao *RoleBindingDAO) GetBinding(principal string) (*model.RoleBinding, error) {
+	value, err := bindingDao.client.Get(getRoleBindingKey(principal))
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	binding := &model.RoleBinding{}
+	if err := json.UnmarshalFromString(value, binding); err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+/**
+ * 获取全部角色绑定
+ */
+func (bindingDao *RoleBindingDAO) GetBindings() (map[string]*model.RoleBinding, error) {
+	values, err := bindingDao.client.GetAll(ROLE_BINDING_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := make(map[string]*model.RoleBinding)
+	for k, v := range values {
+		binding := &model.RoleBinding{}
+		if json.UnmarshalFromString(v, binding) == nil {
+			bindings[k] = binding
+		}
+	}
+	return bindings, nil
+}
+
+/**
+ * 删除调用方的角色绑定
+ */
+func (bindingDao *RoleBindingDAO) DelBinding(principal string) (int64, error) {
+	return bindingDao.client.Delete(getRoleBindingKey(principal))
+}
+
+/**
+ * 升级迁移: 旧的角色绑定不区分调用方类型,存在同名Basic用户时改为user:,否则改为cert:;
+ * 已有类型前缀的绑定不处理,可以重复执行
+ */
+func (bindingDao *RoleBindingDAO) MigrateLegacyBindings() error {
+	values, err := bindingDao.client.GetAll(ROLE_BINDING_PREFIX)
+	if err != nil {
+		return err
+	}
+
+	userDao := NewAdminUserDao()
+	for key := range values {
+		value, revision, err := bindingDao.client.GetWithRevision(key)
+		if err != nil {
+			return err
+		}
+		binding := &model.RoleBinding{}
+		if value == "" || json.UnmarshalFromString(value, binding) != nil {
+			continue
+		}
+		if _, _, ok := model.SplitPrincipal(binding.Principal); ok {
+			continue
+		}
+
+		user, err := userDao.GetUser(binding.Principal)
+		if err != nil {
+			return err
+		}
+		kind := model.PRINCIPAL_CERT
+		if user != nil {
+			kind = model.PRINCIPAL_USER
+		}
+		legacy := binding.Principal
+		binding.Principal = model.PrincipalName(kind, legacy)
+		data, err := json.Marshal(binding)
+		if err != nil {
+			return err
+		}
+
+		//旧绑定未被修改且新key不存在时才迁移
+		newKey := getRoleBindingKey(binding.Principal)
+		committed, err := bindingDao.client.CompareAllAndCommit(map[string]int64{key: revision, newKey: 0},
+			clientv3.OpPut(newKey, string(data)),
+			clientv3.OpDelete(key))
+		if err != nil {
+			return err
+		}
+		if committed > 0 {
+			log.Printf("role binding %s migrated to %s", legacy, binding.Principal)
+		}
+	}
+	return nil
+}
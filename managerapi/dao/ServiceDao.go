@@ -0,0 +1,80 @@
+package DAO
+
+import (
+	"fmt"
+	"skyway/library/DataSource"
+	"skyway/managerapi/model"
+)
+
+type ServiceDAO struct {
+	client *DataSource.EtcdClient
+}
+
+func NewServiceDao() *ServiceDAO {
+	return &ServiceDAO{
+		client: DataSource.GetInstance(),
+	}
+}
+
+const (
+	SERVICE_PREFIX     = "SERVICE_"
+	SERVICE_KEY_FORMAT = "SERVICE_%d"
+)
+
+func getServiceKey(serviceId int) string {
+	return fmt.Sprintf(SERVICE_KEY_FORMAT, serviceId)
+}
+
+/**
+ * 注册或更新服务
+ */
+func (serviceDao *ServiceDAO) RegisterService(service *model.Service) bool {
+	data, err := json.Marshal(service)
+	if err == nil {
+		return serviceDao.client.Put(getServiceKey(service.ServiceId), string(data))
+	}
+	return false
+}
+
+/**
+ * 获取指定服务,不存在时返回nil
+ */
+func (serviceDao *ServiceDAO) GetService(serviceId int) (*model.Service, error) {
+	value, err := serviceDao.client.Get(getServiceKey(serviceId))
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	service := model.NewService()
+	err = json.UnmarshalFromString(value, service)
+	if err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+/**
+ * 获取全部服务列表
+ */
+func (serviceDao *ServiceDAO) GetServices() (map[string]*model.Service, error) {
+	services, err := serviceDao.client.GetAll(SERVICE_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceModels := make(map[string]*model.Service)
+	for k, v := range services {
+		service := model.NewService()
+		if json.UnmarshalFromString(v, service) == nil {
+			serviceModels[k] = service
+		}
+	}
+	return serviceModels, nil
+}
+
+/**
+ * 删除指定服务
+ */
+func (serviceDao *ServiceDAO) DelService(serviceId int) (int64, error) {
+	return serviceDao.client.Delete(getServiceKey(serviceId))
+}
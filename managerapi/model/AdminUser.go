@@ -0,0 +1,12 @@
+package model
+
+/**
+ * 管理接口的用户,用于HTTP Basic认证
+ */
+type AdminUser struct {
+	Username string
+	/**
+	 * bcrypt哈希后的密码
+	 */
+	PasswordHash string
+}
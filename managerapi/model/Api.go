@@ -2,6 +2,14 @@ package model
 
 type patternType int
 
+/**
+ * API状态:草稿为管理端编辑中的定义,发布后复制到环境中由网关加载
+ */
+const (
+	API_STATE_DRAFT     = "draft"
+	API_STATE_PUBLISHED = "published"
+)
+
 type Api struct {
 	/**
 	 * ApiID
@@ -37,10 +45,85 @@ type Api struct {
 	 * 后端接口URI格式
 	 */
 	DestUriPattern string
+	/**
+	 * 请求方法,GET,POST等,为空时默认GET
+	 */
+	Method string
+	/**
+	 * 熔断配置,为空时不熔断
+	 */
+	CircuitBreaker *CircuitBreaker
+	/**
+	 * 重试策略,为空时不重试
+	 */
+	Retry *RetryPolicy
+	/**
+	 * 转发超时,非0的项覆盖服务的设置
+	 */
+	Timeouts *Timeouts
+	/**
+	 * 网关认证配置,为空时不认证
+	 */
+	Auth *AuthPolicy
+	/**
+	 * 限流规则,全部满足才放行
+	 */
+	RateLimits []*RateLimit
+	/**
+	 * 状态,draft或published,草稿修改后重新变为draft
+	 */
+	State string
+	/**
+	 * 发布时所属分组设置的快照,只在已发布的API中有值,晋级时随API一起复制;
+	 * 网关按快照合并分组设置,修改分组后需要重新发布组内API才在各环境生效
+	 */
+	Group *Group `json:",omitempty"`
 }
 
 func NewApi() *Api {
 	return &Api{
-		ApiId: 0,
+		ApiId:  0,
+		Method: "GET",
+		State:  API_STATE_DRAFT,
 	}
 }
+
+/**
+ * API生效的分组: 已发布的API取发布时的快照,草稿取groups中分组的当前设置
+ */
+func (api *Api) EffectiveGroup(groups map[int]*Group) *Group {
+	if api.Group != nil {
+		return api.Group
+	}
+	return groups[api.GroupId]
+}
+
+/**
+ * 返回隐去认证密钥的副本,包括分组快照中的认证配置
+ */
+func (api *Api) Redacted() *Api {
+	if api == nil {
+		return nil
+	}
+	redacted := *api
+	redacted.Auth = api.Auth.Redacted()
+	redacted.Group = api.Group.Redacted()
+	return &redacted
+}
+
+/**
+ * 是否含有认证密钥原文,包括分组快照
+ */
+func (api *Api) HasSecrets() bool {
+	return api != nil && (api.Auth.HasSecrets() || api.Group.HasSecrets())
+}
+
+/**
+ * 发布到环境的副本: 状态为published,带上所属分组当前设置的快照,未分组时group为nil
+ */
+func (api *Api) Published(group *Group) *Api {
+	published := *api
+	published.State = API_STATE_PUBLISHED
+	published.Group = group
+	return &published
+}
@@ -0,0 +1,90 @@
+package model
+
+import "time"
+
+/**
+ * API变更类型
+ */
+const (
+	API_ACTION_CREATE   = "create"
+	API_ACTION_UPDATE   = "update"
+	API_ACTION_DELETE   = "delete"
+	API_ACTION_ROLLBACK = "rollback"
+	API_ACTION_PUBLISH  = "publish"
+	//从环境中下线
+	API_ACTION_UNPUBLISH = "unpublish"
+	//升级迁移时把旧版本API发布到全部环境
+	API_ACTION_MIGRATE = "migrate"
+)
+
+/**
+ * 单个字段的变更
+ */
+type FieldChange struct {
+	Field  string
+	Before interface{}
+	After  interface{}
+}
+
+/**
+ * API的一个历史版本,每次变更追加一条,不修改不删除
+ */
+type ApiVersion struct {
+	ApiId int
+	/**
+	 * 版本号,同一API内从1递增
+	 */
+	Version int64
+	/**
+	 * 变更类型,create/update/delete/rollback/publish/unpublish/migrate
+	 */
+	Action string
+	/**
+	 * 回滚时对应的目标版本号
+	 */
+	RollbackTo int64 `json:",omitempty"`
+	/**
+	 * 发布或下线时的目标环境
+	 */
+	Environment string `json:",omitempty"`
+	/**
+	 * 操作人
+	 */
+	Operator string
+	Time     time.Time
+	/**
+	 * 变更的字段
+	 */
+	Changes []*FieldChange
+	/**
+	 * 变更前的完整记录,创建时为空
+	 */
+	Previous *Api
+	/**
+	 * 变更后的完整记录,删除时为空
+	 */
+	Current *Api
+}
+
+/**
+ * 返回隐去认证密钥的副本;Changes中的字段是解码后的JSON,由调用方另行处理
+ */
+func (v *ApiVersion) Redacted() *ApiVersion {
+	redacted := *v
+	redacted.Previous = v.Previous.Redacted()
+	redacted.Current = v.Current.Redacted()
+	return &redacted
+}
+
+/**
+ * 该版本所属的分组,删除时取删除前的分组
+ */
+func (v *ApiVersion) GroupId() int {
+	if v.Current != nil {
+		return v.Current.GroupId
+	}
+	if v.Previous != nil {
+		return v.Previous.GroupId
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+/**
+ * 管理操作的审计记录,只追加不修改;升级时脱敏密钥等敏感内容除外
+ */
+type AuditRecord struct {
+	Id   string
+	Time time.Time
+	/**
+	 * 操作人,即认证通过的调用方
+	 */
+	Actor string
+	/**
+	 * 调用方IP
+	 */
+	SourceIp string
+	/**
+	 * 操作,如api.create,service.delete
+	 */
+	Action string
+	/**
+	 * 资源标识,如apis/12,users/alice,environments/prod/apis/12
+	 */
+	Resource string
+	/**
+	 * 操作前后的资源内容,创建时Before为空,删除时After为空
+	 */
+	Before interface{}
+	After  interface{}
+}
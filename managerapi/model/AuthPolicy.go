@@ -0,0 +1,238 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+/**
+ * 网关对调用方的认证方式
+ */
+const (
+	AUTH_KEY    = "key"
+	AUTH_JWT    = "jwt"
+	AUTH_HMAC   = "hmac"
+	AUTH_OAUTH2 = "oauth2"
+)
+
+/**
+ * 默认的API Key参数名
+ */
+const (
+	DEFAULT_KEY_HEADER = "X-Api-Key"
+	DEFAULT_KEY_QUERY  = "api_key"
+)
+
+/**
+ * 网关认证配置,为空时不认证
+ */
+type AuthPolicy struct {
+	/**
+	 * 认证方式,key,jwt,hmac或oauth2
+	 */
+	Type string
+	/**
+	 * 携带API Key的请求头,为空时使用X-Api-Key
+	 */
+	KeyHeader string
+	/**
+	 * 携带API Key的QueryString参数,为空时使用api_key
+	 */
+	KeyQuery string
+	/**
+	 * JWT认证配置,Type为jwt时必填
+	 */
+	Jwt *JwtPolicy
+	/**
+	 * HMAC签名认证配置,为空时使用默认值
+	 */
+	Hmac *HmacPolicy
+	/**
+	 * OAuth2令牌自省配置,Type为oauth2时必填
+	 */
+	OAuth2 *OAuth2Policy
+}
+
+/**
+ * JWT认证配置,Token从Authorization: Bearer读取;密钥可来自Secret,PublicKey,JwksFile,JwksName中的一个或多个
+ */
+type JwtPolicy struct {
+	/**
+	 * 允许的签名算法,如HS256,RS256,ES256,为空时允许全部支持的算法
+	 */
+	Algorithms []string
+	/**
+	 * HS256等HMAC算法的共享密钥,只写,读取时为空
+	 */
+	Secret string `json:",omitempty"`
+	/**
+	 * Secret的指纹,只在读取时输出;更新时未传Secret但原样传回指纹表示沿用原Secret
+	 */
+	SecretFingerprint string `json:",omitempty"`
+	/**
+	 * RS256,ES256等算法的PEM格式公钥
+	 */
+	PublicKey string
+	/**
+	 * 网关本地的JWKS文件名,相对网关-jwks-dir指定的目录,不能包含路径;文件修改后自动重新加载
+	 */
+	JwksFile string
+	/**
+	 * 保存在etcd中的JWKS名称
+	 */
+	JwksName string
+	/**
+	 * 要求的签发方iss,为空时不检查
+	 */
+	Issuer string
+	/**
+	 * 接受的aud,Token的aud包含其中之一即可,为空时不检查
+	 */
+	Audiences []string
+	/**
+	 * 必须包含的claim及取值,多个取值用空格分隔且须全部包含,如{"scope": "orders:read"};取值为空时只要求claim存在
+	 */
+	RequiredClaims map[string]string
+	/**
+	 * 转发给后端的claim,claim名称 -> 请求头
+	 */
+	ClaimHeaders map[string]string
+	/**
+	 * 检查exp,nbf时允许的时钟偏差,毫秒
+	 */
+	Leeway int
+	/**
+	 * 为true时接受没有exp的Token,默认拒绝,避免签发的Token永久有效
+	 */
+	AllowMissingExp bool
+}
+
+/**
+ * 携带API Key的请求头
+ */
+func (policy *AuthPolicy) Header() string {
+	if policy.KeyHeader == "" {
+		return DEFAULT_KEY_HEADER
+	}
+	return policy.KeyHeader
+}
+
+/**
+ * 携带API Key的QueryString参数
+ */
+func (policy *AuthPolicy) Query() string {
+	if policy.KeyQuery == "" {
+		return DEFAULT_KEY_QUERY
+	}
+	return policy.KeyQuery
+}
+
+/**
+ * HMAC签名认证配置
+ */
+type HmacPolicy struct {
+	/**
+	 * 请求时间戳与网关时间允许的偏差,毫秒,为0时默认5分钟
+	 */
+	ClockSkew int
+}
+
+/**
+ * OAuth2令牌自省(RFC 7662)配置,Token从Authorization: Bearer读取
+ */
+type OAuth2Policy struct {
+	/**
+	 * 授权服务器的自省地址
+	 */
+	IntrospectionUrl string
+	/**
+	 * 调用自省接口的客户端凭证,通过Basic认证发送
+	 */
+	ClientId string
+	/**
+	 * 只写,读取时为空,规则同JwtPolicy.Secret
+	 */
+	ClientSecret            string `json:",omitempty"`
+	ClientSecretFingerprint string `json:",omitempty"`
+	/**
+	 * 必须包含的scope
+	 */
+	RequiredScopes []string
+	/**
+	 * 转发给后端的subject和scope请求头,为空时使用X-Auth-Subject和X-Auth-Scope
+	 */
+	SubjectHeader string
+	ScopeHeader   string
+	/**
+	 * 自省请求超时,毫秒,为0时默认3秒
+	 */
+	Timeout int
+	/**
+	 * 自省结果的最长缓存时间,毫秒,有效Token最多缓存到exp,无效Token最多缓存10秒;为0时默认5分钟
+	 */
+	CacheTime int
+}
+
+/**
+ * 密钥的指纹,sha256的前8字节,可用于比对密钥是否修改但无法还原
+ */
+func Fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+/**
+ * 是否含有密钥原文
+ */
+func (policy *AuthPolicy) HasSecrets() bool {
+	if policy == nil {
+		return false
+	}
+	return (policy.Jwt != nil && policy.Jwt.Secret != "") || (policy.OAuth2 != nil && policy.OAuth2.ClientSecret != "")
+}
+
+/**
+ * 返回隐去密钥的副本,用于接口输出,审计记录和历史版本;Jwt.Secret和OAuth2.ClientSecret只保留指纹
+ */
+func (policy *AuthPolicy) Redacted() *AuthPolicy {
+	if policy == nil {
+		return nil
+	}
+	redacted := *policy
+	if policy.Jwt != nil && policy.Jwt.Secret != "" {
+		jwt := *policy.Jwt
+		jwt.SecretFingerprint = Fingerprint(jwt.Secret)
+		jwt.Secret = ""
+		redacted.Jwt = &jwt
+	}
+	if policy.OAuth2 != nil && policy.OAuth2.ClientSecret != "" {
+		oauth2 := *policy.OAuth2
+		oauth2.ClientSecretFingerprint = Fingerprint(oauth2.ClientSecret)
+		oauth2.ClientSecret = ""
+		redacted.OAuth2 = &oauth2
+	}
+	return &redacted
+}
+
+/**
+ * 修改时未传入密钥但传回的指纹与原配置exist中密钥的指纹一致,沿用原密钥;指纹不保存
+ */
+func (policy *AuthPolicy) KeepSecrets(exist *AuthPolicy) {
+	if policy == nil {
+		return
+	}
+	if jwt := policy.Jwt; jwt != nil {
+		if jwt.Secret == "" && jwt.SecretFingerprint != "" && exist != nil && exist.Jwt != nil &&
+			exist.Jwt.Secret != "" && Fingerprint(exist.Jwt.Secret) == jwt.SecretFingerprint {
+			jwt.Secret = exist.Jwt.Secret
+		}
+		jwt.SecretFingerprint = ""
+	}
+	if oauth2 := policy.OAuth2; oauth2 != nil {
+		if oauth2.ClientSecret == "" && oauth2.ClientSecretFingerprint != "" && exist != nil && exist.OAuth2 != nil &&
+			exist.OAuth2.ClientSecret != "" && Fingerprint(exist.OAuth2.ClientSecret) == oauth2.ClientSecretFingerprint {
+			oauth2.ClientSecret = exist.OAuth2.ClientSecret
+		}
+		oauth2.ClientSecretFingerprint = ""
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+/**
+ * 熔断配置,统计窗口内错误率或慢调用比例超过阈值时熔断
+ */
+type CircuitBreaker struct {
+	/**
+	 * 统计窗口,单位毫秒,默认10000
+	 */
+	Window int
+	/**
+	 * 窗口内请求数达到多少才开始判断,默认20
+	 */
+	MinRequests int
+	/**
+	 * 错误率阈值,百分比,0不按错误率熔断;5xx和转发错误计为错误
+	 */
+	ErrorRate int
+	/**
+	 * 慢调用耗时,单位毫秒,0不按耗时熔断
+	 */
+	SlowCallDuration int
+	/**
+	 * 慢调用比例阈值,百分比
+	 */
+	SlowCallRate int
+	/**
+	 * 熔断持续时间,之后进入半开状态,单位毫秒,默认30000
+	 */
+	OpenTime int
+	/**
+	 * 半开状态允许通过的探测请求数,全部成功后恢复,默认5
+	 */
+	HalfOpenRequests int
+	/**
+	 * 熔断时返回的状态码,默认503
+	 */
+	FallbackStatus int
+	/**
+	 * 熔断时返回的内容,为空时返回默认错误信息
+	 */
+	FallbackBody string
+	/**
+	 * 熔断时返回的Content-Type
+	 */
+	FallbackContentType string
+}
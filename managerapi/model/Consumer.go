@@ -0,0 +1,57 @@
+package model
+
+/**
+ * API调用方,通过API Key识别,只能调用授权的分组和API
+ */
+type Consumer struct {
+	ConsumerName string
+	Description  string
+	/**
+	 * 调用方持有的API Key,可配置多个以便轮换
+	 */
+	ApiKeys []string
+	/**
+	 * HMAC签名密钥,可配置多个以便轮换
+	 */
+	HmacKeys []*HmacKey
+	/**
+	 * 授权的分组,可调用分组内的全部API
+	 */
+	GroupIds []int
+	/**
+	 * 单独授权的API
+	 */
+	ApiIds []int
+}
+
+/**
+ * HMAC签名密钥,请求通过KeyId指明使用的密钥
+ */
+type HmacKey struct {
+	KeyId  string
+	Secret string
+}
+
+func NewConsumer() *Consumer {
+	return &Consumer{}
+}
+
+/**
+ * 是否允许调用指定API
+ */
+func (consumer *Consumer) Allowed(api *Api) bool {
+	for _, apiId := range consumer.ApiIds {
+		if apiId == api.ApiId {
+			return true
+		}
+	}
+	if api.GroupId == 0 {
+		return false
+	}
+	for _, groupId := range consumer.GroupIds {
+		if groupId == api.GroupId {
+			return true
+		}
+	}
+	return false
+}
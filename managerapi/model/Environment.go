@@ -0,0 +1,33 @@
+package model
+
+/**
+ * 发布环境,按晋级顺序排列
+ */
+const (
+	ENV_TEST    = "test"
+	ENV_STAGING = "staging"
+	ENV_PROD    = "prod"
+)
+
+var Environments = []string{ENV_TEST, ENV_STAGING, ENV_PROD}
+
+func IsEnvironment(name string) bool {
+	for _, env := range Environments {
+		if env == name {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 晋级的下一个环境,已是最后一个或环境不存在时返回空
+ */
+func NextEnvironment(name string) string {
+	for i, env := range Environments {
+		if env == name && i+1 < len(Environments) {
+			return Environments[i+1]
+		}
+	}
+	return ""
+}
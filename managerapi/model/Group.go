@@ -0,0 +1,91 @@
+package model
+
+/**
+ * API分组,分组内的API继承分组的路径前缀和共享配置,API上设置的项覆盖分组的设置;
+ * 分组不区分环境,API发布时保存分组设置的快照,修改分组后重新发布组内API才在各环境生效
+ */
+type Group struct {
+	GroupId     int
+	GroupName   string
+	Description string
+	/**
+	 * 路径前缀,拼接在分组内API的来源URI前,如/user
+	 */
+	BasePath string
+	/**
+	 * 默认的后端服务ID,API的ServiceId为0时使用
+	 */
+	ServiceId int
+	/**
+	 * 默认熔断配置,API未设置时使用
+	 */
+	CircuitBreaker *CircuitBreaker
+	/**
+	 * 默认重试策略,API未设置时使用
+	 */
+	Retry *RetryPolicy
+	/**
+	 * 默认转发超时,API上非0的项覆盖
+	 */
+	Timeouts *Timeouts
+	/**
+	 * 默认认证配置,API未设置时使用
+	 */
+	Auth *AuthPolicy
+	/**
+	 * 分组限流规则,与API自身的限流规则同时生效,按分组计数,分组内的API共用限额
+	 */
+	RateLimits []*RateLimit
+}
+
+func NewGroup() *Group {
+	return &Group{}
+}
+
+/**
+ * 返回隐去认证密钥的副本
+ */
+func (group *Group) Redacted() *Group {
+	if group == nil {
+		return nil
+	}
+	redacted := *group
+	redacted.Auth = group.Auth.Redacted()
+	return &redacted
+}
+
+/**
+ * 是否含有认证密钥原文
+ */
+func (group *Group) HasSecrets() bool {
+	return group != nil && group.Auth.HasSecrets()
+}
+
+/**
+ * 返回合并分组设置后的API副本,group为nil时原样返回;分组的限流规则不合并,由网关按分组单独计数
+ */
+func (group *Group) Apply(api *Api) *Api {
+	if group == nil {
+		return api
+	}
+
+	effective := *api
+	effective.OriginUriPattern = group.BasePath + api.OriginUriPattern
+	if effective.ServiceId == 0 {
+		effective.ServiceId = group.ServiceId
+	}
+	if effective.CircuitBreaker == nil {
+		effective.CircuitBreaker = group.CircuitBreaker
+	}
+	if effective.Retry == nil {
+		effective.Retry = group.Retry
+	}
+	if effective.Auth == nil {
+		effective.Auth = group.Auth
+	}
+	if group.Timeouts != nil {
+		timeouts := group.Timeouts.Merge(api.Timeouts)
+		effective.Timeouts = &timeouts
+	}
+	return &effective
+}
@@ -0,0 +1,59 @@
+package model
+
+/**
+ * 限流算法
+ * token-bucket: 令牌桶,按Limit/Window的速率补充令牌,允许Burst个突发请求
+ * sliding-window: 滑动窗口,任意Window时长内最多Limit个请求
+ */
+const (
+	RATE_LIMIT_TOKEN_BUCKET   = "token-bucket"
+	RATE_LIMIT_SLIDING_WINDOW = "sliding-window"
+)
+
+/**
+ * 限流维度
+ * api: 整个API共用一个计数,分组的规则由分组内所有API共用一个计数
+ * consumer: 按认证通过的调用方或Token的subject计数,未认证时按客户端IP
+ * ip: 按客户端IP计数
+ * header: 按指定请求头的取值计数
+ */
+const (
+	RATE_LIMIT_KEY_API      = "api"
+	RATE_LIMIT_KEY_CONSUMER = "consumer"
+	RATE_LIMIT_KEY_IP       = "ip"
+	RATE_LIMIT_KEY_HEADER   = "header"
+)
+
+/**
+ * 限流规则,超出时返回429
+ */
+type RateLimit struct {
+	/**
+	 * 限流算法,token-bucket或sliding-window
+	 */
+	Algorithm string
+	/**
+	 * 每个Window内允许的请求数
+	 */
+	Limit int
+	/**
+	 * 时间窗口,毫秒
+	 */
+	Window int
+	/**
+	 * 令牌桶容量,为0时等于Limit;只对token-bucket有效
+	 */
+	Burst int
+	/**
+	 * 限流维度,api,consumer,ip或header
+	 */
+	Key string
+	/**
+	 * Key为header时使用的请求头,如X-Forwarded-For
+	 */
+	Header string
+	/**
+	 * 为true时计数保存在etcd中,多个网关实例共享限额
+	 */
+	Shared bool
+}
@@ -0,0 +1,43 @@
+package model
+
+/**
+ * 重试策略
+ */
+type RetryPolicy struct {
+	/**
+	 * 最大尝试次数,包含第一次请求,默认1即不重试
+	 */
+	MaxAttempts int
+	/**
+	 * 需要重试的后端状态码,如502,503,504
+	 */
+	RetryOn []int
+	/**
+	 * 连接失败(拒绝连接,DNS错误,连接超时等)时重试
+	 */
+	RetryOnConnectError bool
+	/**
+	 * 读写超时时重试
+	 */
+	RetryOnTimeout bool
+	/**
+	 * 退避基准时间,第N次重试等待 [0, min(BackoffMax, BackoffBase*2^(N-1))) 内的随机时长,单位毫秒,默认25
+	 */
+	BackoffBase int
+	/**
+	 * 退避最大时间,单位毫秒,默认1000
+	 */
+	BackoffMax int
+	/**
+	 * 重试预算,10秒窗口内重试数不超过请求数的百分比,默认20
+	 */
+	BudgetPercent int
+	/**
+	 * 重试预算的保底值,每秒至少允许的重试数,默认3
+	 */
+	BudgetMinPerSecond int
+	/**
+	 * 是否允许重试POST,PATCH等非幂等请求
+	 */
+	AllowNonIdempotent bool
+}
@@ -0,0 +1,61 @@
+package model
+
+import "strings"
+
+/**
+ * 管理接口的角色
+ * admin: 管理范围内的全部资源,全局admin还可管理服务,分组,用户和角色
+ * group-owner: 读写范围内分组的API
+ * read-only: 只读范围内分组的API
+ */
+const (
+	ROLE_ADMIN       = "admin"
+	ROLE_GROUP_OWNER = "group-owner"
+	ROLE_READ_ONLY   = "read-only"
+)
+
+/**
+ * 授予的角色,GroupId为0表示全部分组
+ */
+type Grant struct {
+	Role    string
+	GroupId int
+}
+
+/**
+ * 角色绑定的调用方类型,Basic用户和客户端证书各自独立,证书CommonName与用户名相同时不共享角色
+ */
+const (
+	PRINCIPAL_USER = "user"
+	PRINCIPAL_CERT = "cert"
+)
+
+/**
+ * 角色绑定中的调用方,格式为<类型>:<名称>,如user:alice,cert:deploy-bot
+ */
+func PrincipalName(kind string, name string) string {
+	return kind + ":" + name
+}
+
+/**
+ * 拆分调用方为类型和名称,格式不合法时ok为false
+ */
+func SplitPrincipal(principal string) (kind string, name string, ok bool) {
+	index := strings.Index(principal, ":")
+	if index < 0 {
+		return "", "", false
+	}
+	kind, name = principal[:index], principal[index+1:]
+	if (kind != PRINCIPAL_USER && kind != PRINCIPAL_CERT) || name == "" {
+		return "", "", false
+	}
+	return kind, name, true
+}
+
+/**
+ * 调用方的角色绑定,Principal为user:用户名或cert:证书CommonName
+ */
+type RoleBinding struct {
+	Principal string
+	Grants    []*Grant
+}
@@ -0,0 +1,120 @@
+package model
+
+/**
+ * 后端服务节点
+ */
+type Target struct {
+	/**
+	 * 节点地址 host:port
+	 */
+	Addr string
+	/**
+	 * 权重,默认1
+	 */
+	Weight int
+}
+
+/**
+ * 主动健康检查,定时请求节点的Path,2xx/3xx视为成功
+ */
+type HealthCheck struct {
+	/**
+	 * 检查路径,为空时不做主动检查
+	 */
+	Path string
+	/**
+	 * 检查间隔,单位毫秒,默认5000
+	 */
+	Interval int
+	/**
+	 * 单次检查超时,单位毫秒,默认1000
+	 */
+	Timeout int
+	/**
+	 * 连续成功多少次恢复为健康,默认2
+	 */
+	HealthyThreshold int
+	/**
+	 * 连续失败多少次标记为不健康,默认3
+	 */
+	UnhealthyThreshold int
+}
+
+/**
+ * 被动健康检查,根据转发结果摘除异常节点
+ */
+type OutlierDetection struct {
+	/**
+	 * 连续5xx或连接错误多少次后摘除,0不启用
+	 */
+	MaxFailures int
+	/**
+	 * 摘除时长,单位毫秒,默认30000
+	 */
+	EjectTime int
+}
+
+/**
+ * 负载均衡策略
+ */
+const (
+	LB_ROUND_ROBIN          = "round-robin"
+	LB_WEIGHTED_ROUND_ROBIN = "weighted-round-robin"
+	LB_LEAST_CONN           = "least-conn"
+	LB_RANDOM_TWO           = "random-two"
+	LB_HASH                 = "hash"
+)
+
+/**
+ * 一致性哈希的取值来源
+ */
+const (
+	HASH_ON_HEADER = "header"
+	HASH_ON_COOKIE = "cookie"
+	HASH_ON_IP     = "ip"
+)
+
+type Service struct {
+	/**
+	 * 服务ID
+	 */
+	ServiceId int
+	/**
+	 * 服务名称
+	 */
+	ServiceName string
+	/**
+	 * 后端节点列表
+	 */
+	Targets []*Target
+	/**
+	 * 负载均衡策略,为空时默认round-robin
+	 */
+	LbStrategy string
+	/**
+	 * 一致性哈希取值来源: header,cookie,ip,仅LbStrategy为hash时有效
+	 */
+	HashOn string
+	/**
+	 * 一致性哈希使用的header或cookie名称
+	 */
+	HashKey string
+	/**
+	 * 主动健康检查,为空时不检查
+	 */
+	HealthCheck *HealthCheck
+	/**
+	 * 被动健康检查,为空时不检查
+	 */
+	OutlierDetection *OutlierDetection
+	/**
+	 * 默认转发超时,JSON中与其他字段平铺
+	 */
+	Timeouts
+}
+
+func NewService() *Service {
+	return &Service{
+		ServiceId: 0,
+	}
+}
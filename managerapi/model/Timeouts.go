@@ -0,0 +1,60 @@
+package model
+
+/**
+ * 转发超时,单位毫秒,0表示不限制;服务上设置默认值,API上非0的项覆盖服务的设置
+ */
+type Timeouts struct {
+	/**
+	 * 建立连接超时
+	 */
+	ConnectTimeout int
+	/**
+	 * 读取响应超时,从开始读取响应头到读完响应
+	 */
+	ReadTimeout int
+	/**
+	 * 写请求超时
+	 */
+	WriteTimeout int
+	/**
+	 * 整个请求的总超时,包含排队,连接,读写
+	 */
+	RequestTimeout int
+	/**
+	 * 空闲连接保持时间,超过后关闭,0使用默认值10秒
+	 */
+	IdleTimeout int
+}
+
+/**
+ * 是否有负数的超时设置
+ */
+func (t *Timeouts) Negative() bool {
+	return t.ConnectTimeout < 0 || t.ReadTimeout < 0 || t.WriteTimeout < 0 ||
+		t.RequestTimeout < 0 || t.IdleTimeout < 0
+}
+
+/**
+ * 用override中非0的项覆盖当前设置,返回新的设置
+ */
+func (t Timeouts) Merge(override *Timeouts) Timeouts {
+	if override == nil {
+		return t
+	}
+	if override.ConnectTimeout != 0 {
+		t.ConnectTimeout = override.ConnectTimeout
+	}
+	if override.ReadTimeout != 0 {
+		t.ReadTimeout = override.ReadTimeout
+	}
+	if override.WriteTimeout != 0 {
+		t.WriteTimeout = override.WriteTimeout
+	}
+	if override.RequestTimeout != 0 {
+		t.RequestTimeout = override.RequestTimeout
+	}
+	if override.IdleTimeout != 0 {
+		t.IdleTimeout = override.IdleTimeout
+	}
+	return t
+}
@@ -0,0 +1,400 @@
+package validator
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"skyway/gateway/skyjwt"
+	"skyway/gateway/skyrewrite"
+	"skyway/gateway/skyrouter"
+	"skyway/managerapi/model"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * 单个字段的校验错误
+ */
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+/**
+ * 校验失败,包含全部字段错误
+ */
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldError := range e.Errors {
+		messages = append(messages, fieldError.Field+": "+fieldError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, &FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+var (
+	paramRegexp = regexp.MustCompile(`\{\w+\}`)
+	destRegexp  = regexp.MustCompile(`\$(\d+)`)
+)
+
+/**
+ * 把API注册到路由,路由树冲突等panic转为error
+ */
+func handle(router *skyrouter.Router, api *model.Api) (err error) {
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			err = fmt.Errorf("%v", rcv)
+		}
+	}()
+
+	rewrite := skyrewrite.New()
+	rewrite.ApiId = api.ApiId
+	rewrite.DestUri = api.DestUriPattern
+	router.Handle(api.Method, api.OriginUriPattern, rewrite)
+	return nil
+}
+
+/**
+ * 检查来源URI和目标URI: 来源URI能编译为重写规则,目标URI中每个$N都有对应的{param}
+ */
+func validatePatterns(api *model.Api, result *ValidationError) bool {
+	if api.OriginUriPattern == "" || api.OriginUriPattern[0] != '/' {
+		result.add("OriginUriPattern", "must begin with '/'")
+		return false
+	}
+
+	rewrite := skyrewrite.New()
+	rewrite.OriginUri = api.OriginUriPattern
+	rewrite.DestUri = api.DestUriPattern
+	if err := rewrite.Compile(); err != nil {
+		result.add("OriginUriPattern", "%s", err)
+		return false
+	}
+	if err := handle(skyrouter.New(), api); err != nil {
+		result.add("OriginUriPattern", "%s", err)
+		return false
+	}
+
+	if api.DestUriPattern == "" {
+		result.add("DestUriPattern", "is required")
+		return false
+	}
+	params := len(paramRegexp.FindAllString(rewrite.OriginUri, -1)) + len(rewrite.QueryParams)
+	valid := true
+	for _, match := range destRegexp.FindAllStringSubmatch(api.DestUriPattern, -1) {
+		n, _ := strconv.Atoi(match[1])
+		if n < 1 || n > params {
+			result.add("DestUriPattern", "'%s' has no matching {param} in OriginUriPattern, which has %d", match[0], params)
+			valid = false
+		}
+	}
+	return valid
+}
+
+/**
+ * 检查与已有API的路由冲突,existing中与api相同ApiId的记录会被跳过
+ */
+func validateConflicts(api *model.Api, existing []*model.Api, result *ValidationError) {
+	others := make([]*model.Api, 0, len(existing))
+	for _, other := range existing {
+		if other.ApiId != api.ApiId && other.Method == api.Method {
+			others = append(others, other)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].ApiId < others[j].ApiId
+	})
+
+	//逐个与已有API组成路由,找出冲突的API
+	for _, other := range others {
+		router := skyrouter.New()
+		if handle(router, other) != nil {
+			continue
+		}
+		if err := handle(router, api); err != nil {
+			result.add("OriginUriPattern", "conflicts with api %d (%s %s): %s", other.ApiId, other.Method, other.OriginUriPattern, err)
+		}
+	}
+}
+
+/**
+ * 检查熔断,重试,超时配置的取值范围
+ */
+func validateSettings(breaker *model.CircuitBreaker, retry *model.RetryPolicy, timeouts *model.Timeouts, result *ValidationError) {
+	if timeouts != nil && timeouts.Negative() {
+		result.add("Timeouts", "must not be negative")
+	}
+	if retry != nil {
+		if retry.MaxAttempts < 0 || retry.BackoffBase < 0 || retry.BackoffMax < 0 || retry.BudgetMinPerSecond < 0 {
+			result.add("Retry", "must not be negative")
+		}
+		if retry.BudgetPercent < 0 || retry.BudgetPercent > 100 {
+			result.add("Retry.BudgetPercent", "must be between 0 and 100")
+		}
+		for _, code := range retry.RetryOn {
+			if code < 100 || code > 599 {
+				result.add("Retry.RetryOn", "invalid status code %d", code)
+			}
+		}
+	}
+	if breaker != nil {
+		if breaker.Window < 0 || breaker.MinRequests < 0 || breaker.SlowCallDuration < 0 ||
+			breaker.OpenTime < 0 || breaker.HalfOpenRequests < 0 {
+			result.add("CircuitBreaker", "must not be negative")
+		}
+		if breaker.ErrorRate < 0 || breaker.ErrorRate > 100 || breaker.SlowCallRate < 0 || breaker.SlowCallRate > 100 {
+			result.add("CircuitBreaker", "rates must be between 0 and 100")
+		}
+		if breaker.FallbackStatus != 0 && (breaker.FallbackStatus < 100 || breaker.FallbackStatus > 599) {
+			result.add("CircuitBreaker.FallbackStatus", "invalid status code %d", breaker.FallbackStatus)
+		}
+	}
+}
+
+/**
+ * 检查限流规则
+ */
+func validateRateLimits(limits []*model.RateLimit, result *ValidationError) {
+	for i, limit := range limits {
+		field := fmt.Sprintf("RateLimits[%d]", i)
+		if limit == nil {
+			result.add(field, "must not be null")
+			continue
+		}
+		switch limit.Algorithm {
+		case model.RATE_LIMIT_TOKEN_BUCKET, model.RATE_LIMIT_SLIDING_WINDOW:
+		default:
+			result.add(field+".Algorithm", "must be %s or %s", model.RATE_LIMIT_TOKEN_BUCKET, model.RATE_LIMIT_SLIDING_WINDOW)
+		}
+		if limit.Limit <= 0 {
+			result.add(field+".Limit", "must be positive")
+		}
+		if limit.Window <= 0 {
+			result.add(field+".Window", "must be positive")
+		}
+		if limit.Burst < 0 {
+			result.add(field+".Burst", "must not be negative")
+		}
+		switch limit.Key {
+		case model.RATE_LIMIT_KEY_API, model.RATE_LIMIT_KEY_CONSUMER, model.RATE_LIMIT_KEY_IP:
+		case model.RATE_LIMIT_KEY_HEADER:
+			if limit.Header == "" {
+				result.add(field+".Header", "is required when Key is header")
+			}
+		default:
+			result.add(field+".Key", "must be one of %s, %s, %s, %s",
+				model.RATE_LIMIT_KEY_API, model.RATE_LIMIT_KEY_CONSUMER, model.RATE_LIMIT_KEY_IP, model.RATE_LIMIT_KEY_HEADER)
+		}
+	}
+}
+
+/**
+ * 检查JWT认证配置: 至少一个密钥来源,算法受支持,公钥能解析
+ */
+func validateJwt(jwt *model.JwtPolicy, result *ValidationError) {
+	if jwt == nil {
+		result.add("Auth.Jwt", "is required for jwt auth")
+		return
+	}
+	if jwt.Secret == "" && jwt.PublicKey == "" && jwt.JwksFile == "" && jwt.JwksName == "" {
+		result.add("Auth.Jwt", "one of Secret, PublicKey, JwksFile or JwksName is required")
+	}
+	for _, alg := range jwt.Algorithms {
+		if !skyjwt.IsAlgorithm(alg) {
+			result.add("Auth.Jwt.Algorithms", "unsupported algorithm '%s', must be one of %s", alg, strings.Join(skyjwt.Algorithms, ","))
+		}
+	}
+	if jwt.PublicKey != "" {
+		if _, err := skyjwt.ParsePublicKey(jwt.PublicKey); err != nil {
+			result.add("Auth.Jwt.PublicKey", "%s", err)
+		}
+	}
+	if jwt.JwksFile != "" && !skyjwt.IsFileName(jwt.JwksFile) {
+		result.add("Auth.Jwt.JwksFile", "must be a file name in the gateway jwks directory, not a path")
+	}
+	if jwt.Leeway < 0 {
+		result.add("Auth.Jwt.Leeway", "must not be negative")
+	}
+	for claim, header := range jwt.ClaimHeaders {
+		if claim == "" || header == "" {
+			result.add("Auth.Jwt.ClaimHeaders", "claim and header must not be empty")
+			break
+		}
+	}
+}
+
+/**
+ * 检查OAuth2令牌自省配置
+ */
+func validateOAuth2(oauth2 *model.OAuth2Policy, result *ValidationError) {
+	if oauth2 == nil {
+		result.add("Auth.OAuth2", "is required for oauth2 auth")
+		return
+	}
+	endpoint, err := url.Parse(oauth2.IntrospectionUrl)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		result.add("Auth.OAuth2.IntrospectionUrl", "must be an absolute http or https url")
+	}
+	if oauth2.ClientId == "" {
+		result.add("Auth.OAuth2.ClientId", "is required")
+	}
+	if oauth2.Timeout < 0 || oauth2.CacheTime < 0 {
+		result.add("Auth.OAuth2", "Timeout and CacheTime must not be negative")
+	}
+}
+
+/**
+ * 检查认证配置
+ */
+func validateAuth(auth *model.AuthPolicy, result *ValidationError) {
+	if auth == nil {
+		return
+	}
+	switch auth.Type {
+	case model.AUTH_KEY:
+	case model.AUTH_JWT:
+		validateJwt(auth.Jwt, result)
+	case model.AUTH_OAUTH2:
+		validateOAuth2(auth.OAuth2, result)
+	case model.AUTH_HMAC:
+		if auth.Hmac != nil && auth.Hmac.ClockSkew < 0 {
+			result.add("Auth.Hmac.ClockSkew", "must not be negative")
+		}
+	default:
+		result.add("Auth.Type", "unknown auth type '%s'", auth.Type)
+	}
+}
+
+/**
+ * 保存前校验API定义,services为全部服务,groups为全部分组,existing为已保存的API;
+ * 服务和路由按合并分组设置后的结果检查;校验失败返回*ValidationError
+ */
+func ValidateApi(api *model.Api, services map[int]*model.Service, groups map[int]*model.Group, existing []*model.Api) error {
+	result := &ValidationError{}
+
+	if api.ApiName == "" {
+		result.add("ApiName", "is required")
+	}
+	switch api.Method {
+	case "GET", "HEAD", "OPTIONS", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		result.add("Method", "unknown method '%s'", api.Method)
+	}
+	group := api.EffectiveGroup(groups)
+	if api.GroupId != 0 && group == nil {
+		result.add("GroupId", "group %d not found", api.GroupId)
+	}
+
+	effective := group.Apply(api)
+	if services[effective.ServiceId] == nil {
+		result.add("ServiceId", "service %d not found", effective.ServiceId)
+	}
+	if validatePatterns(effective, result) {
+		others := make([]*model.Api, 0, len(existing))
+		for _, other := range existing {
+			others = append(others, other.EffectiveGroup(groups).Apply(other))
+		}
+		validateConflicts(effective, others, result)
+	}
+	validateSettings(api.CircuitBreaker, api.Retry, api.Timeouts, result)
+	validateAuth(api.Auth, result)
+	validateRateLimits(api.RateLimits, result)
+
+	if len(result.Errors) > 0 {
+		return result
+	}
+	return nil
+}
+
+/**
+ * 保存前校验分组定义,services为全部服务;校验失败返回*ValidationError
+ */
+func ValidateGroup(group *model.Group, services map[int]*model.Service) error {
+	result := &ValidationError{}
+
+	if group.GroupName == "" {
+		result.add("GroupName", "is required")
+	}
+	if path := group.BasePath; path != "" {
+		switch {
+		case path[0] != '/':
+			result.add("BasePath", "must begin with '/'")
+		case path[len(path)-1] == '/':
+			result.add("BasePath", "must not end with '/'")
+		case strings.ContainsAny(path, "{}?:*"):
+			//前缀中的参数会打乱API中$N的编号
+			result.add("BasePath", "must not contain params or query string")
+		}
+	}
+	if group.ServiceId != 0 && services[group.ServiceId] == nil {
+		result.add("ServiceId", "service %d not found", group.ServiceId)
+	}
+	validateSettings(group.CircuitBreaker, group.Retry, group.Timeouts, result)
+	validateAuth(group.Auth, result)
+	validateRateLimits(group.RateLimits, result)
+
+	if len(result.Errors) > 0 {
+		return result
+	}
+	return nil
+}
+
+/**
+ * 分组修改后,检查组内API合并新设置后是否仍然合法;已发布的API保留发布时的分组快照,
+ * 按重新发布后使用新设置检查,避免之后无法发布;
+ * apis按环境分别传入全部API,草稿的key为空字符串;错误字段以环境和API ID为前缀
+ */
+func ValidateGroupApis(group *model.Group, services map[int]*model.Service, groups map[int]*model.Group, apis map[string][]*model.Api) error {
+	updated := make(map[int]*model.Group, len(groups)+1)
+	for groupId, other := range groups {
+		updated[groupId] = other
+	}
+	updated[group.GroupId] = group
+
+	result := &ValidationError{}
+	for env, list := range apis {
+		prefix := ""
+		if env != "" {
+			prefix = fmt.Sprintf("Environments[%s].", env)
+		}
+		//组内API去掉快照,按新设置合并
+		members := make([]*model.Api, 0)
+		existing := make([]*model.Api, 0, len(list))
+		for _, api := range list {
+			if api.GroupId == group.GroupId {
+				member := *api
+				member.Group = nil
+				api = &member
+				members = append(members, api)
+			}
+			existing = append(existing, api)
+		}
+		for _, api := range members {
+			err := ValidateApi(api, services, updated, existing)
+			if apiResult, ok := err.(*ValidationError); ok {
+				for _, fieldError := range apiResult.Errors {
+					result.add(fmt.Sprintf("%sApis[%d].%s", prefix, api.ApiId, fieldError.Field), "%s", fieldError.Message)
+				}
+			}
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		sort.SliceStable(result.Errors, func(i, j int) bool {
+			return result.Errors[i].Field < result.Errors[j].Field
+		})
+		return result
+	}
+	return nil
+}